@@ -4,49 +4,66 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/contig"
 	"github.com/emepyc/Blast2lca/taxonomy"
+	"github.com/emepyc/Blast2lca/xopen"
 )
 
 const VERSION = 0.8
 
 const (
-	DEFAULT_BLAST_BUFFER_SIZE = 200 * 1024 * 1024 // Default size for blast reader is 200Mb. TODO -- Try other sizes and profile
+	DEFAULT_BLAST_BUFFER_SIZE = 1024 * 1024 // Default size for blast reader is 1Mb (lines are not copied out of it)
+	BLOCKS_PER_WORKER         = 64          // Channels hold this many blocks (or results) per worker
 )
 
 var (
-	cpuprofile, memprofile                              string
-	procsflag                                           int
-	dictflag, nodesflag, namesflag, blastfile, taxlevel string
-	savememflag, verflag, helpflag                      bool
-	// order                                               bool
-	bscLimFactor float64
-	printfLock   sync.Mutex // TODO: Try to avoid this mutex -- Print from a channel -- Make it optionally ordered
-	totalQueries int
+	cpuprofile, memprofile                        string
+	procsflag                                     int
+	dictflag, nodesflag, namesflag, taxlevel      string
+	manifestflag, outdirflag, outextflag          string
+	excludeflag, includeflag, pairedflag          string
+	contigsflag, orfrxflag, binsflag, binsoutflag string
+	outflag                                       string
+	hspsflag, scoreflag                           string
+	savememflag, verflag, helpflag, statsflag     bool
+	reportzerosflag                               bool
+	longreadsflag, order, strict, unsortedflag    bool
+	tmpdirflag, informatflag, samscoreflag        string
+	mmseqsfieldsflag, queriesflag, reportflag     string
+	outformatflag, columnsflag                    string
+	outFmt                                        outFormat
+	sortMem, scoreMode                            int
+	bscLimFactor, lrCover, contigFrac             float64
+	minScore, topPct                              float64
+	hitFilters                                    []blastm8.HitFilter // Applied to the hits of each query before the LCA
+	totalQueries                                  int64               // Updated atomically by the workers
+	totalExcluded                                 int64               // Updated atomically by the workers
+	totalMalformed                                int64               // Updated atomically by the reader and the workers
+	totalRepeats                                  int64               // Updated atomically by the reader
+	// Queries by status (why they can't be assigned), updated atomically by the workers
+	totalStatus  [blastm8.NumStatus]int64
+	totalMissing int64 // Queries of the -queries files without hits in the blast files
 )
 
-// printf performs threadsafe prints to os.Stdout
-func printf(format string, args ...interface{}) {
-	printfLock.Lock()
-	fmt.Printf(format, args...)
-	printfLock.Unlock()
-}
-
 func init() {
-	flag.IntVar(&procsflag, "nprocs", 4, "Number of cpus for multithreading [optional]")
+	flag.IntVar(&procsflag, "nprocs", 4, "Number of workers (and cpus) for multithreading [optional]")
 	flag.StringVar(&nodesflag, "nodes", "nodes.dmp", "nodes.dmp file of taxonomy")
 	flag.StringVar(&namesflag, "names", "names.dmp", "names.dmp file of taxonomy")
-	flag.StringVar(&dictflag, "dict", "", "Dict file of taxonomy")
+	flag.StringVar(&dictflag, "dict", "", "Dict file of taxonomy, maps the GIs of the subject ids (gi|N|) of the hits without a taxid")
 	flag.StringVar(&taxlevel, "levels", "", "Desired LCA taxonomical levels [optional]")
 	flag.BoolVar(&savememflag, "savemem", false, "Save memory by keeping the gi2taxid mapping file in disk [optional]")
 	flag.BoolVar(&verflag, "version", false, "Print VERSION and exits")
@@ -54,11 +71,45 @@ func init() {
 	flag.StringVar(&cpuprofile, "cpuprof", "", "Write cpu profile to file")
 	flag.StringVar(&memprofile, "memprof", "", "Write mem profile to file")
 	flag.Float64Var(&bscLimFactor, "bsfactor", 0.9, "Limit factor for bit score significance")
-	// flag.BoolVar(&order, "order", false, "Keep the sequences output in the same order as in the input blast file")
+	flag.StringVar(&excludeflag, "exclude", "", "Comma separated taxids, name patterns, presets or @files of taxa excluded from the LCA [optional]")
+	flag.StringVar(&includeflag, "include", "", "Comma separated taxids, name patterns, presets or @files of the only taxa used in the LCA [optional]")
+	flag.BoolVar(&longreadsflag, "longreads", false, "Interval-aware LCA for long reads and contigs (uses the query coordinates of the hits) [optional]")
+	flag.Float64Var(&lrCover, "lrcover", 0.8, "Fraction of the covered query length that the assigned taxon must cover in -longreads mode (0.5-1]")
+	flag.StringVar(&pairedflag, "paired", "", "Classify both mates of each fragment jointly using the \"union\" or the \"intersection\" of their hit taxa [optional]")
+	flag.StringVar(&hspsflag, "hsps", "", "Collapse the HSPs of each subject summing their bit scores over non-overlapping query intervals (\"sum\") or keeping the best one (\"max\") [optional]")
+	flag.StringVar(&scoreflag, "score", "bits", "Score used for thresholding: bit score (\"bits\"), bit score per aligned column (\"percol\") or per query length (\"perqlen\", only changes -minscore)")
+	flag.Float64Var(&minScore, "minscore", 0, "Minimum score (see -score) of the hits [optional]")
+	flag.Float64Var(&topPct, "toppct", 0, "Keep the hits with score within this percent of the best score (0-100), instead of -bsfactor [optional]")
+	flag.StringVar(&contigsflag, "contigs", "", "Write the classification of the contigs from the assignments of their ORFs to this file [optional]")
+	flag.StringVar(&orfrxflag, "orfrx", contig.DefaultOrfRx, "Regexp whose first group extracts the contig name from the ORF name")
+	flag.Float64Var(&contigFrac, "contigfrac", 0.5, "Fraction of the ORFs bit score that must support a contig or bin classification")
+	flag.StringVar(&binsflag, "bins", "", "Contig to bin mapping file (tab separated) for bin classification (needs -contigs and -binsout) [optional]")
+	flag.StringVar(&binsoutflag, "binsout", "", "Write the classification of the bins to this file [optional]")
+	flag.BoolVar(&statsflag, "stats", false, "Append the confidence and supporting evidence columns to the output [optional]")
+	flag.BoolVar(&order, "order", false, "Keep the sequences output in the same order as in the input blast file")
+	flag.StringVar(&outflag, "out", xopen.Stdio, "Output file, compressed if it ends in .gz, .bz2 or .zst (- for the standard output)")
+	flag.StringVar(&manifestflag, "manifest", "", "Tab separated file with the sample names and blast files to classify, instead of the blast files arguments [optional]")
+	flag.StringVar(&outdirflag, "outdir", "", "Write the output of each sample to its own file in this directory instead of to -out [optional]")
+	flag.StringVar(&outextflag, "outext", ".lca", "Extension of the output files in -outdir (add .gz, .bz2 or .zst to compress them)")
+	flag.StringVar(&informatflag, "informat", "auto", "Format of the input files: "+formatNames()+" or \"auto\" to detect it")
+	flag.StringVar(&samscoreflag, "samscore", blastm8.SAMScore, "Score of SAM/BAM alignments: alignment score (\"AS\", added to the aligned length if <= 0) or mapping quality (\"MAPQ\")")
+	flag.StringVar(&mmseqsfieldsflag, "mmseqsfields", blastm8.MMseqsFields, "Columns of MMseqs2 files without a header line (its --format-output)")
+	flag.StringVar(&outformatflag, "outformat", "legacy", "Output format: "+strings.Join(outFormatNames, ", "))
+	flag.StringVar(&columnsflag, "columns", defaultColumns, "Comma separated columns of the tsv output format")
+	flag.StringVar(&queriesflag, "queries", "", "FASTA, FASTQ or list of names of the queries, to write the ones without hits too [optional]")
+	flag.StringVar(&reportflag, "report", "", "Write a Kraken-style report of the queries in each clade to this file (%s is replaced by the sample name) [optional]")
+	flag.BoolVar(&reportzerosflag, "reportzeros", false, "Write all the taxa of the taxonomy in the -report, also the ones without queries")
+	flag.BoolVar(&unsortedflag, "unsorted", false, "Group the hits by query when the lines of a query are not contiguous in the blast file (sorts it on disk) [optional]")
+	flag.StringVar(&tmpdirflag, "tmpdir", os.TempDir(), "Directory for the temporary files of -unsorted")
+	flag.IntVar(&sortMem, "sortmem", blastm8.DefaultSortMemory/(1024*1024), "Megabytes of blast lines sorted in memory by -unsorted before using temporary files")
+	flag.BoolVar(&strict, "strict", false, "Abort on the first malformed blast line (or record of other input formats) instead of skipping it")
+}
+
+// parseFlags parses and checks the command line. The krona subcommand has its own flags (see kronaMain)
+func parseFlags() {
 	flag.Parse()
 
-	// The blast file is the first unparsed argument
-	blastfile = flag.Arg(0)
+	// The blast files are the unparsed arguments
 	if verflag {
 		fmt.Printf("blast2lca\nVERSION: %.3f\n\n", VERSION)
 		os.Exit(0)
@@ -66,94 +117,483 @@ func init() {
 	if helpflag {
 		fmt.Printf("blast2lca\n")
 		flag.Usage()
+		fmt.Printf("\nRun \"blast2lca %s -help\" for the options of the subcommand that writes Krona charts\n\n", kronaCommand)
 		os.Exit(0)
 	}
 
-	if blastfile == "" {
+	if flag.NArg() == 0 && manifestflag == "" {
 		fmt.Printf("blast2lca\n")
 		flag.Usage()
-		fmt.Printf("\nA blast file is mandatory\n\n")
+		fmt.Printf("\nA blast file (- for the standard input), several ones or a -manifest are mandatory\n\n")
+		os.Exit(1)
+	}
+	if longreadsflag && (lrCover <= 0.5 || lrCover > 1) {
+		fmt.Fprintf(os.Stderr, "ERROR: -lrcover must be greater than 0.5 and not greater than 1\n")
+		os.Exit(1)
+	}
+	if pairedflag != "" && pairedflag != "union" && pairedflag != "intersection" {
+		fmt.Fprintf(os.Stderr, "ERROR: -paired must be \"union\" or \"intersection\"\n")
+		os.Exit(1)
+	}
+	if manifestflag != "" && flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: the blast files must be given either as arguments or in the -manifest\n")
 		os.Exit(1)
 	}
+	if (binsflag != "" || binsoutflag != "") && (binsflag == "" || binsoutflag == "" || contigsflag == "") {
+		fmt.Fprintf(os.Stderr, "ERROR: bin classification needs -contigs, -bins and -binsout\n")
+		os.Exit(1)
+	}
+	switch hspsflag {
+	case "":
+	case "sum":
+		hitFilters = append(hitFilters, blastm8.CollapseHSPs(blastm8.HSPSum))
+	case "max":
+		hitFilters = append(hitFilters, blastm8.CollapseHSPs(blastm8.HSPMax))
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: -hsps must be \"sum\" or \"max\"\n")
+		os.Exit(1)
+	}
+	setflags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setflags[f.Name] = true })
+	var ok bool
+	if scoreMode, ok = blastm8.ScoreMode(scoreflag); !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: -score must be \"bits\", \"percol\" or \"perqlen\"\n")
+		os.Exit(1)
+	}
+	if minScore > 0 {
+		hitFilters = append(hitFilters, blastm8.MinScore(scoreMode, minScore))
+	}
+	var err error
+	if bscLimFactor, err = scoreLimit(setflags, bscLimFactor, topPct); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if !longreadsflag { // In -longreads mode the bit score limit is applied per interval
+		hitFilters = append(hitFilters, blastm8.ScoreFactor(scoreMode, bscLimFactor))
+	}
+	if _, ok := blastm8.FormatByName(informatflag); !ok && informatflag != "auto" {
+		fmt.Fprintf(os.Stderr, "ERROR: -informat must be %s or \"auto\"\n", formatNames())
+		os.Exit(1)
+	}
+	switch samscoreflag {
+	case "AS", "MAPQ":
+		blastm8.SAMScore = samscoreflag
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: -samscore must be \"AS\" or \"MAPQ\"\n")
+		os.Exit(1)
+	}
+	blastm8.MMseqsFields = mmseqsfieldsflag
+	blastm8.StrictConvert = strict
+	if outFmt, err = newOutFormat(outformatflag, columnsflag); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if sortMem < 1 {
+		sortMem = 1
+	}
+	if procsflag < 1 {
+		procsflag = 1
+	}
 	runtime.GOMAXPROCS(procsflag)
 }
 
-func output(outResChan <-chan string, done chan<- struct{}) {
-	for {
+// scoreLimit returns the score limit factor of the hits: bsfactor or the one given by toppct if it is
+// in setflags (the flags given in the command line)
+func scoreLimit(setflags map[string]bool, bsfactor, toppct float64) (float64, error) {
+	if !setflags["toppct"] {
+		return bsfactor, nil
+	}
+	if setflags["bsfactor"] {
+		return 0, errors.New("-toppct and -bsfactor can't be given together")
+	}
+	if toppct <= 0 || toppct >= 100 {
+		return 0, errors.New("-toppct must be greater than 0 and lower than 100")
+	}
+	return 1 - toppct/100, nil
+}
+
+// formatNames returns the names of the supported input formats (quoted and comma separated)
+func formatNames() string {
+	names := make([]string, 0, len(blastm8.InputFormats))
+	for _, f := range blastm8.InputFormats {
+		names = append(names, fmt.Sprintf("%q", f.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// job is a block of the input with its position in the input
+type job struct {
+	index int
+	block *blastm8.BlastBlock
+}
+
+// result is the output line (and the assignment) of the job with the same index
+type result struct {
+	index int
+	rec   *blastm8.QueryRes
+	line  string
+}
+
+// dispatch numbers the blocks from blastChan and passes them to jobChan, that is closed at the end.
+// If window is not nil, a token is put in the window for each block, bounding the number of blocks in flight
+func dispatch(blastChan <-chan *blastm8.BlastBlock, jobChan chan<- *job, window chan<- struct{}, quit <-chan struct{}) error {
+	defer close(jobChan)
+	index := 0
+	for block := range blastChan {
+		if window != nil {
+			select {
+			case window <- struct{}{}:
+			case <-quit:
+				return nil
+			}
+		}
 		select {
-		case outStr, ok := <-outResChan:
-			if ok {
-				printf(outStr)
-			} else {
-				done <- struct{}{}
-				return
+		case jobChan <- &job{index: index, block: block}:
+		case <-quit:
+			return nil
+		}
+		index++
+	}
+	return nil
+}
+
+// collectors gather the results of the queries written to the output. The nil ones are not used
+type collectors struct {
+	contigs *contig.Classifier // Classification of the contigs (-contigs)
+	written querySet           // Names of the queries written (-queries)
+	report  *cladeReport       // Clade counts (-report)
+}
+
+func (c *collectors) add(rec *blastm8.QueryRes) {
+	if c.contigs != nil {
+		c.contigs.Add(rec)
+	}
+	if c.written != nil {
+		c.written.add(rec.Query)
+	}
+	if c.report != nil {
+		c.report.add(rec)
+	}
+}
+
+// output writes the results to w until outResChan is closed, adding them to col.
+// If window is not nil, the results are resequenced and written in input order (taking a token from the window
+// for each one), otherwise they are written as they come
+func output(outResChan <-chan *result, w *bufio.Writer, col *collectors, window <-chan struct{}) error {
+	pending := make(map[int]*result)
+	next := 0
+	write := func(res *result) error {
+		col.add(res.rec)
+		_, err := w.WriteString(res.line)
+		return err
+	}
+	for res := range outResChan {
+		if window == nil {
+			if err := write(res); err != nil {
+				return err
 			}
-		default:
+			continue
+		}
+		pending[res.index] = res
+		for res, ok := pending[next]; ok; res, ok = pending[next] {
+			if err := write(res); err != nil {
+				return err
+			}
+			delete(pending, next)
+			next++
+			<-window
 		}
 	}
+	return w.Flush()
 }
 
-func bl2lca(BlastChan <-chan *blastm8.BlastBlock, taxDB *taxonomy.Taxonomy, levs [][]byte, outResChan chan<- string) {
-	for {
-		select {
-		case queryBlock, ok := <-BlastChan:
-			if ok {
-				totalQueries++
-				queryRec := blastm8.ParseRecord(*queryBlock, bscLimFactor)
-				taxids := make([]int, 0, len(queryRec.Hits))
-				for _, gibs := range queryRec.Hits {
-					taxid, err := taxDB.TaxidFromGi(gibs.GI())
-					if err != nil {
-						log.Printf("WARNING: Taxid can't be retrieved from %d -- Ignoring this record\n", gibs.GI())
-						continue
-					} else {
-						taxids = append(taxids, taxid)
-					}
-				}
-				var atLevs [][]byte
-				var allLevs []byte
-				lcaNode, err := taxDB.LCA(taxids...)
-				if err != nil {
-					atLevs = make([][]byte, 1)
-					atLevs[0] = taxonomy.Unknown
-				} else {
-					if len(levs[0]) != 0 {
-						atLevs = taxDB.AtLevels(lcaNode, levs...)
-					}
-				}
-				allLevs = bytes.Join(atLevs, []byte{';'})
-				// log.Printf("%s", queryRec.Query)
-				msg := fmt.Sprintf("%s\t%s\t%s\t%s\n", queryRec.Query, lcaNode.Name, lcaNode.Taxon, allLevs)
-				// fmt.Print(msg)
-				// printf(msg);
-				outResChan <- msg
-			} else {
-				close(outResChan)
-				// done <- struct{}{}
-				return
+// atLevels returns the taxa at the levs levels of taxid (-1 for unknown taxa, that are unknown at every level).
+// levs has an empty level if no levels were given
+func atLevels(taxDB *taxonomy.Taxonomy, taxid int, levs [][]byte) [][]byte {
+	if len(levs) == 0 || len(levs[0]) == 0 {
+		return nil
+	}
+	if taxid == -1 {
+		atLevs := make([][]byte, len(levs))
+		for i := range atLevs {
+			atLevs[i] = taxonomy.Unknown
+		}
+		return atLevs
+	}
+	return taxDB.AtLevels(taxDB.Node(taxid), levs...)
+}
+
+// describe returns the name, rank and taxons at the levs levels of taxid (-1 for unknown taxa)
+func describe(taxDB *taxonomy.Taxonomy, taxid int, levs [][]byte) (name, rank, allLevs []byte) {
+	if taxid != -1 {
+		lcaNode := taxDB.Node(taxid)
+		name, rank = lcaNode.Name, lcaNode.Taxon
+	}
+	return name, rank, bytes.Join(atLevels(taxDB, taxid, levs), []byte{';'})
+}
+
+// writeContigs writes the contig or bin classifications to w, prefixed with the tag column if it is not empty
+func writeContigs(w *xopen.Writer, tag string, results []*contig.Result, taxDB *taxonomy.Taxonomy, levs [][]byte) error {
+	for _, res := range results {
+		name, rank, allLevs := describe(taxDB, res.Taxid, levs)
+		if tag != "" {
+			w.WriteString(tag)
+			w.WriteByte('\t')
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%.3f\n", res.Name, name, rank, allLevs, res.NOrfs, res.NVoting, res.Support); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bl2lca is the classification worker. It parses the blocks from jobChan, maps their hits
+// and calculates their LCA until jobChan is closed. The output lines are tagged with tag if it is not empty.
+// Several workers can share the same channels
+func bl2lca(jobChan <-chan *job, taxDB *taxonomy.Taxonomy, filter *taxonomy.Filter, levs [][]byte, tag string, outResChan chan<- *result, quit <-chan struct{}) error {
+	for nextJob := range jobChan {
+		queryBlock := nextJob.block
+		atomic.AddInt64(&totalQueries, 1)
+		var queryRec *blastm8.QueryRes
+		var err error
+		queryRec = blastm8.ParseRecordFilters(*queryBlock, hitFilters...)
+		if mate := queryBlock.Mate(); mate != nil {
+			mateRec := blastm8.ParseRecordFilters(*mate, hitFilters...)
+			queryRec.Errors = append(queryRec.Errors, mateRec.Errors...)
+			queryRec = blastm8.MergeMates(queryRec, mateRec, taxDB, pairedflag == "intersection")
+		}
+		if len(queryRec.Errors) > 0 {
+			if strict {
+				return queryRec.Errors[0]
 			}
-		default:
+			atomic.AddInt64(&totalMalformed, int64(len(queryRec.Errors)))
+		}
+		if longreadsflag {
+			err = queryRec.AssignIntervals(taxDB, filter, scoreMode, bscLimFactor, lrCover)
+		} else {
+			err = queryRec.Assign(taxDB, filter)
+		}
+		atomic.AddInt64(&totalExcluded, int64(queryRec.Stats.Excluded))
+		if err != nil {
+			queryRec.Taxid = -1
+		}
+		atomic.AddInt64(&totalStatus[queryRec.Status], 1)
+		msg := outFmt.line(taxDB, queryRec, levs, tag)
+		select {
+		case outResChan <- &result{index: nextJob.index, rec: queryRec, line: msg}:
+		case <-quit:
+			return nil
+		}
+	}
+	return nil
+}
+
+// classify runs the classification pipeline on the blast file of a sample and writes the results to w,
+// tagged with the sample name if tagged is set. The ORFs are added to contigs if it is not nil.
+// If the sample has a queries file, its queries without hits are written at the end.
+// With -report, the clade report of the sample is written too
+func classify(s sample, tagged bool, w *bufio.Writer, taxDB *taxonomy.Taxonomy, filter *taxonomy.Filter, levs [][]byte, contigs *contig.Classifier) error {
+	blastbuf, err := xopen.OpenSize(s.path, DEFAULT_BLAST_BUFFER_SIZE)
+	if err != nil {
+		return err
+	}
+	defer blastbuf.Close()
+	format, ok := blastm8.FormatByName(informatflag)
+	if !ok {
+		format = blastm8.DetectFormat(blastbuf.Reader)
+	}
+	p := newPipeline()
+	m8buf, convert := format.M8Reader(blastbuf.Reader, blastbuf.ReaderAt(), DEFAULT_BLAST_BUFFER_SIZE, p.quit)
+	if convert != nil {
+		p.Go(convert)
+	}
+	tag := ""
+	if tagged {
+		tag = s.name
+	}
+
+	chanSize := procsflag * BLOCKS_PER_WORKER
+	blastBlockChan := make(chan *blastm8.BlastBlock, chanSize)
+	outResChan := make(chan *result, chanSize)
+	jobChan := make(chan *job, chanSize)
+	var window chan struct{}
+	if order { // Bounds the results waiting to be resequenced
+		window = make(chan struct{}, 2*chanSize)
+	}
+	readChan := blastBlockChan // The stages below replace blastBlockChan, the reader must keep its own
+	if unsortedflag {
+		p.Go(func() error {
+			skipped, err := blastm8.SortFile(m8buf, readChan, strict, tmpdirflag, sortMem*1024*1024, p.quit)
+			atomic.AddInt64(&totalMalformed, int64(skipped))
+			return err
+		})
+	} else {
+		p.Go(func() error {
+			skipped, err := blastm8.Procfile(m8buf, readChan, strict, p.quit)
+			atomic.AddInt64(&totalMalformed, int64(skipped))
+			return err
+		})
+		checkedChan := make(chan *blastm8.BlastBlock, chanSize)
+		inChan := blastBlockChan
+		p.Go(func() error {
+			atomic.AddInt64(&totalRepeats, int64(blastm8.WarnRepeats(inChan, checkedChan, p.quit)))
+			return nil
+		})
+		blastBlockChan = checkedChan
+	}
+	if pairedflag != "" {
+		pairedChan := make(chan *blastm8.BlastBlock, chanSize)
+		inChan := blastBlockChan
+		p.Go(func() error { blastm8.PairMates(inChan, pairedChan, p.quit); return nil })
+		blastBlockChan = pairedChan
+	}
+	p.Go(func() error { return dispatch(blastBlockChan, jobChan, window, p.quit) })
+	var workers sync.WaitGroup
+	workers.Add(procsflag)
+	for i := 0; i < procsflag; i++ {
+		p.Go(func() error {
+			defer workers.Done()
+			return bl2lca(jobChan, taxDB, filter, levs, tag, outResChan, p.quit)
+		})
+	}
+	p.Go(func() error {
+		workers.Wait()
+		close(outResChan)
+		return nil
+	})
+	col := &collectors{contigs: contigs}
+	if s.queries != "" {
+		col.written = make(querySet)
+	}
+	if reportflag != "" {
+		col.report = newCladeReport()
+	}
+	p.Go(func() error { return output(outResChan, w, col, window) })
+	if err := p.Wait(); err != nil {
+		return err
+	}
+	if col.written != nil {
+		missing, err := writeMissing(s.queries, w, tag, col, levs)
+		atomic.AddInt64(&totalMissing, int64(missing))
+		atomic.AddInt64(&totalStatus[blastm8.NoHits], int64(missing))
+		if err != nil {
+			return fmt.Errorf("Unable to read the queries file %s: %s", s.queries, err)
+		}
+		if err := w.Flush(); err != nil {
+			return err
 		}
 	}
+	if col.report != nil {
+		return writeReport(reportPath(s), col.report, taxDB)
+	}
+	return nil
+}
+
+// outputPath is the output file of a sample in -outdir: its name with the -outext extension
+func outputPath(s sample) string {
+	return filepath.Join(outdirflag, s.name+outextflag)
+}
+
+// reportPath is the -report file of a sample, with %s replaced by the sample name
+func reportPath(s sample) string {
+	return strings.Replace(reportflag, "%s", s.name, -1)
+}
+
+// writeReport writes the clade report to path
+func writeReport(path string, report *cladeReport, taxDB *taxonomy.Taxonomy) error {
+	out, err := xopen.Create(path)
+	if err != nil {
+		return fmt.Errorf("Unable to create file %s: %s", path, err)
+	}
+	if err := report.write(out, taxDB, reportzerosflag); err != nil {
+		out.Close()
+		return fmt.Errorf("Unable to write %s: %s", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Unable to write %s: %s", path, err)
+	}
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == kronaCommand {
+		kronaMain(os.Args[2:])
+		return
+	}
+	parseFlags()
 	levs := bytes.Split([]byte(taxlevel), []byte{':'})
+	samples := make([]sample, 0, flag.NArg())
+	if manifestflag != "" {
+		var err error
+		if samples, err = readManifest(manifestflag); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR : Unable to read the manifest: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, path := range flag.Args() {
+		samples = append(samples, sample{name: sampleName(path), path: path})
+	}
+	for i := range samples {
+		if samples[i].queries == "" {
+			samples[i].queries = queriesflag
+		}
+	}
+	if err := checkSamples(samples); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR : %s\n", err)
+		os.Exit(1)
+	}
+	if reportflag != "" && len(samples) > 1 && !strings.Contains(reportflag, "%s") {
+		fmt.Fprintf(os.Stderr, "ERROR : -report must contain %%s (replaced by the sample name) with several samples\n")
+		os.Exit(1)
+	}
+	// The results of several samples in the same output are tagged with a sample column
+	tagged := outdirflag == "" && (len(samples) > 1 || manifestflag != "")
+
 	taxDB, err := taxonomy.New(nodesflag, namesflag, dictflag, savememflag)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR : Impossible to get a valid Taxonomy: %s\n", err)
 		os.Exit(1)
 	}
-
-	// BLAST
-	blastf, eopen := os.OpenFile(blastfile, os.O_RDONLY, 0644) // Use os.Open instead?
-	if eopen != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Unable to open file %s: %s\n", blastfile, eopen)
+	filter, err := taxDB.NewFilter(excludeflag, includeflag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR : Invalid exclusion/inclusion list: %s\n", err)
 		os.Exit(1)
 	}
-	defer blastf.Close()
+	var contigsOut, binsOut *xopen.Writer
+	var contig2bin map[string]string
+	if contigsflag != "" {
+		if _, err = contig.New(taxDB, orfrxflag, contigFrac); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR : Invalid contig classification options: %s\n", err)
+			os.Exit(1)
+		}
+		if contigsOut, err = xopen.Create(contigsflag); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to create file %s: %s\n", contigsflag, err)
+			os.Exit(1)
+		}
+	}
+	if binsflag != "" {
+		contig2bin, err = contig.LoadBins(binsflag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR : Unable to read the contig to bin mapping: %s\n", err)
+			os.Exit(1)
+		}
+		if binsOut, err = xopen.Create(binsoutflag); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to create file %s: %s\n", binsoutflag, err)
+			os.Exit(1)
+		}
+	}
 
-	blastbuf := bufio.NewReaderSize(io.Reader(blastf), DEFAULT_BLAST_BUFFER_SIZE)
+	var stdout *xopen.Writer
+	if outdirflag == "" {
+		if stdout, err = xopen.Create(outflag); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to create file %s: %s\n", outflag, err)
+			os.Exit(1)
+		}
+		stdout.WriteString(outFmt.header(levs, tagged))
+	} else if err = os.MkdirAll(outdirflag, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Unable to create directory %s: %s\n", outdirflag, err)
+		os.Exit(1)
+	}
 
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
@@ -173,20 +613,88 @@ func main() {
 		f.Close()
 	}
 
-	blastBlockChan := make(chan *blastm8.BlastBlock, 200)
-	done := make(chan struct{})
 	t1 := time.Now()
-
-	outResChan := make(chan string, 200)
-
-	go blastm8.Procfile(blastbuf, blastBlockChan)
-	go bl2lca(blastBlockChan, taxDB, levs, outResChan)
-	go output(outResChan, done)
-	<-done
+	for _, s := range samples {
+		before := atomic.LoadInt64(&totalQueries)
+		out, outname := stdout, outflag
+		if outdirflag != "" {
+			outname = outputPath(s)
+			if out, err = xopen.Create(outname); err != nil {
+				log.Fatalf("ERROR: Unable to create file %s: %s\n", outname, err)
+			}
+			out.WriteString(outFmt.header(levs, false))
+		}
+		tag := ""
+		if tagged || (contigsOut != nil && len(samples) > 1) {
+			tag = s.name
+		}
+		var contigs *contig.Classifier
+		if contigsOut != nil {
+			contigs, _ = contig.New(taxDB, orfrxflag, contigFrac)
+		}
+		if err := classify(s, tagged, out.Writer, taxDB, filter, levs, contigs); err != nil {
+			out.Close()
+			log.Fatalf("ERROR: %s: %s\n", s.path, err)
+		}
+		if outdirflag != "" {
+			if err := out.Close(); err != nil {
+				log.Fatalf("ERROR: Unable to write %s: %s\n", outname, err)
+			}
+		}
+		if contigs != nil {
+			if err := writeContigs(contigsOut, tag, contigs.Contigs(), taxDB, levs); err != nil {
+				log.Fatalf("ERROR: Unable to write the contig classification: %s\n", err)
+			}
+		}
+		if contig2bin != nil {
+			if err := writeContigs(binsOut, tag, contigs.Bins(contig2bin), taxDB, levs); err != nil {
+				log.Fatalf("ERROR: Unable to write the bin classification: %s\n", err)
+			}
+		}
+		nqueries := atomic.LoadInt64(&totalQueries) - before
+		if nqueries == 0 {
+			log.Printf("WARNING: No queries found in %s\n", s.path)
+		}
+		if len(samples) > 1 {
+			log.Printf("%s: %d sequences analyzed\n", s.name, nqueries)
+		}
+	}
+	for _, w := range []*xopen.Writer{stdout, contigsOut, binsOut} {
+		if w == nil {
+			continue
+		}
+		if err := w.Close(); err != nil {
+			log.Fatalf("ERROR: Unable to write the output: %s\n", err)
+		}
+	}
 
 	t2 := time.Now()
 	dur := t2.Sub(t1)
 	secs := dur.Seconds()
 	log.Printf("%d sequences analyzed in %.3f seconds (%d sequences per second)\n", totalQueries, secs, int32(float64(totalQueries)/secs))
+	unassigned := make([]string, 0, blastm8.NumStatus)
+	for st := blastm8.Assigned + 1; st < blastm8.NumStatus; st++ {
+		if n := totalStatus[st]; n > 0 {
+			unassigned = append(unassigned, fmt.Sprintf("%d %s", n, st))
+		}
+	}
+	if len(unassigned) > 0 {
+		log.Printf("%d queries assigned, unassigned ones: %s\n", totalStatus[blastm8.Assigned], strings.Join(unassigned, ", "))
+	}
+	if n := totalStatus[blastm8.UnmappedSubjects]; n > 0 && dictflag == "" {
+		log.Printf("WARNING: %d queries %s without a GI dictionary (-dict maps the GIs of the subjects without taxid)\n", n, blastm8.UnmappedSubjects)
+	}
+	if totalMissing > 0 {
+		log.Printf("%d queries of the queries files without hits in the blast files (written as %s)\n", totalMissing, blastm8.NoHits)
+	}
+	if malformed := totalMalformed + blastm8.ConvertSkipped(); malformed > 0 {
+		log.Printf("%d malformed blast lines skipped (use -strict to abort on them)\n", malformed)
+	}
+	if totalRepeats > 0 {
+		log.Printf("WARNING: %d queries found in more than one block, their blocks were classified separately (use -unsorted to group them)\n", totalRepeats)
+	}
+	if excludeflag != "" || includeflag != "" {
+		log.Printf("%d hits excluded from the LCA by the exclusion/inclusion lists\n", totalExcluded)
+	}
 
 }
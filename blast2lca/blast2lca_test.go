@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+func TestScoreLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		setflags []string
+		bsfactor float64
+		toppct   float64
+		want     float64
+		err      string
+	}{
+		{name: "default bsfactor", bsfactor: 0.9, want: 0.9},
+		{name: "bsfactor", setflags: []string{"bsfactor"}, bsfactor: 0.8, want: 0.8},
+		{name: "toppct", setflags: []string{"toppct"}, bsfactor: 0.9, toppct: 10, want: 0.9},
+		{name: "toppct ignores the default bsfactor", setflags: []string{"toppct"}, bsfactor: 0.9, toppct: 25, want: 0.75},
+		{name: "toppct and bsfactor", setflags: []string{"bsfactor", "toppct"}, bsfactor: 0.8, toppct: 10, err: "-toppct and -bsfactor can't be given together"},
+		{name: "zero toppct", setflags: []string{"toppct"}, toppct: 0, err: "-toppct must be greater than 0 and lower than 100"},
+		{name: "toppct of 100", setflags: []string{"toppct"}, toppct: 100, err: "-toppct must be greater than 0 and lower than 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setflags := make(map[string]bool)
+			for _, name := range tt.setflags {
+				setflags[name] = true
+			}
+			got, err := scoreLimit(setflags, tt.bsfactor, tt.toppct)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("scoreLimit() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("scoreLimit() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// setGlobals sets the flags used by classify and restores them at the end of the test
+func setGlobals(t *testing.T, nprocs int, ordered, strictMode bool) {
+	t.Helper()
+	oldProcs, oldOrder, oldStrict, oldInformat, oldOutFmt, oldFilters := procsflag, order, strict, informatflag, outFmt, hitFilters
+	t.Cleanup(func() {
+		procsflag, order, strict, informatflag, outFmt, hitFilters = oldProcs, oldOrder, oldStrict, oldInformat, oldOutFmt, oldFilters
+	})
+	procsflag, order, strict, informatflag, outFmt = nprocs, ordered, strictMode, "m8", legacyFormat{}
+	hitFilters = []blastm8.HitFilter{blastm8.ScoreFactor(blastm8.ScoreBits, 0.9)}
+}
+
+// shuffledHits returns the m8 lines of n queries, whose names are in random order, and the query names in input order
+func shuffledHits(n int) (string, []string) {
+	rng := rand.New(rand.NewSource(1))
+	taxa := []int{562, 83333, 1224, 1239, 1386}
+	var m8 strings.Builder
+	queries := make([]string, 0, n)
+	for _, i := range rng.Perm(n) {
+		query := fmt.Sprintf("q%d", i)
+		queries = append(queries, query)
+		nhits := 1 + rng.Intn(3)
+		for h := 0; h < nhits; h++ {
+			fmt.Fprintf(&m8, "%s\ts%d\t100.00\t100\t0\t0\t1\t100\t1\t100\t1e-20\t%d\t%d\n", query, h, 100-h, taxa[rng.Intn(len(taxa))])
+		}
+	}
+	return m8.String(), queries
+}
+
+// runClassify classifies the hits of path and returns the output
+func runClassify(t *testing.T, taxDB *taxonomy.Taxonomy, path string, nprocs int, ordered bool) string {
+	t.Helper()
+	setGlobals(t, nprocs, ordered, false)
+	var out bytes.Buffer
+	if err := classify(sample{name: "s", path: path}, false, bufio.NewWriter(&out), taxDB, nil, nil, nil); err != nil {
+		t.Fatalf("classify() error = %v", err)
+	}
+	return out.String()
+}
+
+// sortedLines returns the lines of out in lexical order
+func sortedLines(out string) []string {
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestClassifyWorkers(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	input, queries := shuffledHits(2000)
+	path := writeTemp(t, "hits.m8", input)
+	serial := sortedLines(runClassify(t, taxDB, path, 1, false))
+	if len(serial) != len(queries) {
+		t.Fatalf("classify() with a worker wrote %d lines, want %d", len(serial), len(queries))
+	}
+	if parallel := sortedLines(runClassify(t, taxDB, path, 8, false)); !reflect.DeepEqual(parallel, serial) {
+		t.Errorf("classify() with 8 workers wrote different results than a single worker")
+	}
+}
+
+func TestClassifyOrder(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	input, queries := shuffledHits(2000)
+	path := writeTemp(t, "hits.m8", input)
+	serial := runClassify(t, taxDB, path, 1, false)
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(serial, "\n"), "\n") {
+		got = append(got, strings.SplitN(line, "\t", 2)[0])
+	}
+	if !reflect.DeepEqual(got, queries) {
+		t.Fatalf("classify() with a worker didn't write the %d queries in input order", len(queries))
+	}
+	for i := 0; i < 5; i++ {
+		if parallel := runClassify(t, taxDB, path, 8, true); parallel != serial {
+			t.Fatalf("classify() with 8 workers in order mode wrote a different output than a single worker")
+		}
+	}
+}
+
+// errWriter fails on every write after waiting for delay
+type errWriter struct {
+	delay time.Duration
+}
+
+func (w errWriter) Write([]byte) (int, error) {
+	time.Sleep(w.delay)
+	return 0, errors.New("disk full")
+}
+
+func TestClassifyErrors(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	input, _ := shuffledHits(5000)
+	lines := strings.SplitAfter(input, "\n")
+	badLine := "qbad\ts0\t100.00\t100\t0\t0\t1\t100\t1\t100\t1e-20\tx\t562\n"
+	malformed := strings.Join(lines[:len(lines)/2], "") + badLine + strings.Join(lines[len(lines)/2:], "")
+	tests := []struct {
+		name    string
+		input   string
+		w       io.Writer
+		ordered bool
+		strict  bool
+		want    string
+	}{
+		// The output fails once the stages are blocked writing to their full channels
+		{"output error", input, errWriter{100 * time.Millisecond}, false, false, "disk full"},
+		{"output error in order mode", input, errWriter{100 * time.Millisecond}, true, false, "disk full"},
+		{"malformed line in strict mode", malformed, io.Discard, true, true, "invalid number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobals(t, 8, tt.ordered, tt.strict)
+			path := writeTemp(t, "hits.m8", tt.input)
+			done := make(chan error, 1)
+			go func() {
+				done <- classify(sample{name: "s", path: path}, false, bufio.NewWriterSize(tt.w, 64), taxDB, nil, nil, nil)
+			}()
+			select {
+			case err := <-done:
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("classify() error = %v, want %q", err, tt.want)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("classify() didn't return after the error")
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+	"github.com/emepyc/Blast2lca/xopen"
+)
+
+// kronaCommand is the subcommand that writes the input of Krona (https://github.com/marbl/Krona) from the results:
+//
+//	blast2lca krona -nodes nodes.dmp -names names.dmp -out chart.xml sample1.lca sample2.lca
+//	ktImportXML chart.xml
+const kronaCommand = "krona"
+
+const (
+	kronaTaxonURL   = "https://www.ncbi.nlm.nih.gov/Taxonomy/Browser/wwwtax.cgi?mode=Info&id="
+	kronaUnassigned = "Unassigned"
+)
+
+// kronaDataset has the queries of a sample assigned to each taxid (-1 for the unassigned ones)
+type kronaDataset struct {
+	name      string
+	magnitude map[int]float64 // Sum of the magnitudes of the queries
+	count     map[int]int64   // Number of queries
+}
+
+func (d *kronaDataset) add(taxid int, magnitude float64) {
+	d.magnitude[taxid] += magnitude
+	d.count[taxid]++
+}
+
+// kronaData are the datasets of a chart, in the order they are found
+type kronaData struct {
+	datasets []*kronaDataset
+	byName   map[string]*kronaDataset
+}
+
+// dataset returns the dataset of a sample, adding it if it is new
+func (k *kronaData) dataset(name string) *kronaDataset {
+	if d, ok := k.byName[name]; ok {
+		return d
+	}
+	d := &kronaDataset{name: name, magnitude: make(map[int]float64), count: make(map[int]int64)}
+	k.datasets = append(k.datasets, d)
+	k.byName[name] = d
+	return d
+}
+
+// resultReader reads the results of the queries in any of the output formats. The format is detected from the
+// first line: a JSON object (jsonl), a header line with the query and taxid columns (tsv) or else the legacy format,
+// whose taxa are found by their names and ranks
+type resultReader struct {
+	taxDB     *taxonomy.Taxonomy
+	tagged    bool             // The legacy results start with the sample column
+	names     map[string][]int // Taxids of each name, built for the first legacy file
+	ambiguous map[string]bool  // Legacy names of several taxa of the same rank (see parseLegacy)
+	unknown   int64            // Results whose taxa are not in the taxonomy (counted as unassigned)
+}
+
+// resultParser returns the sample ("" if the result doesn't have it), the query and the taxid (-1 for unassigned
+// queries) of a result line
+type resultParser func(line []byte) (sample string, query []byte, taxid int, err error)
+
+// read calls fn with each result of path
+func (r *resultReader) read(path string, fn func(sample string, query []byte, taxid int)) error {
+	buf, err := xopen.Open(path)
+	if err != nil {
+		return err
+	}
+	defer buf.Close()
+	var parse resultParser
+	for nline := 1; ; nline++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			header := false
+			if parse == nil {
+				parse, header = r.parser(line)
+			}
+			if !header {
+				sample, query, taxid, perr := parse(line)
+				if perr != nil {
+					return fmt.Errorf("%s:%d: %s", path, nline, perr)
+				}
+				fn(sample, query, taxid)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parser returns the parser of the format of a file from its first line, and whether it is a header line
+func (r *resultReader) parser(first []byte) (resultParser, bool) {
+	if first[0] == '{' {
+		return r.parseJSON, false
+	}
+	cols := make(map[string]int)
+	for i, col := range strings.Split(string(first), "\t") {
+		cols[col] = i
+	}
+	iquery, hasQuery := cols["query"]
+	itaxid, hasTaxid := cols["taxid"]
+	if hasQuery && hasTaxid {
+		isample, hasSample := cols["sample"]
+		return func(line []byte) (string, []byte, int, error) {
+			fields := bytes.Split(line, []byte{'\t'})
+			if len(fields) != len(cols) {
+				return "", nil, 0, fmt.Errorf("expected %d tab separated fields as in the header, found %d", len(cols), len(fields))
+			}
+			taxid, err := strconv.Atoi(string(fields[itaxid]))
+			if err != nil {
+				return "", nil, 0, fmt.Errorf("invalid taxid %s", fields[itaxid])
+			}
+			sample := ""
+			if hasSample {
+				sample = string(fields[isample])
+			}
+			return sample, fields[iquery], r.known(taxid), nil
+		}, true
+	}
+	if r.names == nil {
+		r.names = r.taxDB.NameIndex()
+		r.ambiguous = make(map[string]bool)
+	}
+	return r.parseLegacy, false
+}
+
+// known returns taxid, or -1 for unassigned queries (taxid 0) and taxa that are not in the taxonomy
+func (r *resultReader) known(taxid int) int {
+	if taxid <= 0 {
+		return -1
+	}
+	if !r.taxDB.Has(taxid) {
+		r.unknown++
+		return -1
+	}
+	return taxid
+}
+
+func (r *resultReader) parseJSON(line []byte) (string, []byte, int, error) {
+	var res jsonResult
+	if err := json.Unmarshal(line, &res); err != nil {
+		return "", nil, 0, err
+	}
+	return res.Sample, []byte(res.Query), r.known(res.Taxid), nil
+}
+
+// parseLegacy finds the taxon of a legacy result by its name and rank. Unassigned queries have no name
+// If several taxa of the rank have the name, the first one is used: the lowest taxid, as NameIndex sorts them
+func (r *resultReader) parseLegacy(line []byte) (string, []byte, int, error) {
+	fields := bytes.Split(line, []byte{'\t'})
+	sample := ""
+	if r.tagged && len(fields) > 0 {
+		sample, fields = string(fields[0]), fields[1:]
+	}
+	if len(fields) < 3 {
+		return "", nil, 0, errors.New("expected query, name and rank")
+	}
+	query, name, rank := fields[0], string(fields[1]), fields[2]
+	if name == "" {
+		return sample, query, -1, nil
+	}
+	taxid := -1
+	for _, t := range r.names[name] {
+		if !bytes.Equal(r.taxDB.Node(t).Taxon, rank) {
+			continue
+		}
+		if taxid != -1 {
+			r.ambiguous[name] = true
+			break
+		}
+		taxid = t
+	}
+	if taxid == -1 {
+		r.unknown++
+	}
+	return sample, query, taxid, nil
+}
+
+// readMagnitudes reads the magnitude of each query from a tab separated file (# for comments)
+func readMagnitudes(path string) (map[string]float64, error) {
+	buf, err := xopen.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	magnitudes := make(map[string]float64)
+	for nline := 1; ; nline++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l := bytes.TrimSpace(line); len(l) > 0 && l[0] != '#' {
+			parts := bytes.Split(l, []byte{'\t'})
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("%s:%d: expected query name and magnitude", path, nline)
+			}
+			m, perr := strconv.ParseFloat(string(bytes.TrimSpace(parts[1])), 64)
+			if perr != nil || m < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid magnitude %s", path, nline, parts[1])
+			}
+			magnitudes[string(bytes.TrimSpace(parts[0]))] = m
+		}
+		if err == io.EOF {
+			return magnitudes, nil
+		}
+	}
+}
+
+func formatMagnitude(m float64) string {
+	return strconv.FormatFloat(m, 'f', -1, 64)
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeKronaText writes a dataset in the text format of ktImportText: the magnitude of each taxon followed by
+// the names of its lineage, without the root (so the queries assigned to the root only have the magnitude).
+// The lines are sorted by lineage
+func writeKronaText(w io.Writer, d *kronaDataset, taxDB *taxonomy.Taxonomy) error {
+	lineages := make(map[int]string, len(d.magnitude))
+	taxids := make([]int, 0, len(d.magnitude))
+	for taxid := range d.magnitude {
+		names := []string{kronaUnassigned}
+		if taxid != -1 {
+			lineage := taxDB.Lineage(taxid)
+			names = make([]string, len(lineage))
+			for i, t := range lineage {
+				names[len(lineage)-1-i] = string(taxDB.Node(t).Name)
+			}
+		}
+		lineages[taxid] = strings.Join(names, "\t")
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return lineages[taxids[i]] < lineages[taxids[j]] })
+	bw := bufio.NewWriter(w)
+	for _, taxid := range taxids {
+		bw.WriteString(formatMagnitude(d.magnitude[taxid]))
+		if lineages[taxid] != "" {
+			bw.WriteString("\t" + lineages[taxid])
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// writeKronaXML writes the datasets in the XML format of ktImportXML: the taxa from the root with the magnitudes of
+// their clades in each dataset, their ranks and their taxids (linked to the NCBI Taxonomy browser).
+// withCount adds the number of queries of the clades, that differs from the magnitude if the queries are weighted
+func writeKronaXML(w io.Writer, k *kronaData, taxDB *taxonomy.Taxonomy, withCount bool) error {
+	n := len(k.datasets)
+	mags := make(map[int][]float64) // Magnitude of the clade of each taxid in each dataset
+	counts := make(map[int][]int64)
+	add := func(taxid, i int, m float64, c int64) {
+		if mags[taxid] == nil {
+			mags[taxid], counts[taxid] = make([]float64, n), make([]int64, n)
+		}
+		mags[taxid][i] += m
+		counts[taxid][i] += c
+	}
+	for i, d := range k.datasets {
+		for taxid, m := range d.magnitude {
+			add(rootTaxid, i, m, d.count[taxid])
+			if taxid == -1 {
+				add(-1, i, m, d.count[taxid])
+			}
+			for _, t := range taxDB.Lineage(taxid) { // Without the root
+				add(t, i, m, d.count[taxid])
+			}
+		}
+	}
+	total := func(taxid int) float64 {
+		sum := 0.0
+		for _, m := range mags[taxid] {
+			sum += m
+		}
+		return sum
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("<krona>\n<attributes magnitude=\"magnitude\">\n")
+	bw.WriteString("\t<attribute display=\"Total\">magnitude</attribute>\n")
+	if withCount {
+		bw.WriteString("\t<attribute display=\"Queries\">count</attribute>\n")
+	}
+	bw.WriteString("\t<attribute display=\"Rank\" mono=\"true\">rank</attribute>\n")
+	fmt.Fprintf(bw, "\t<attribute display=\"Taxon\" mono=\"true\" hrefBase=\"%s\">taxid</attribute>\n", xmlText(kronaTaxonURL))
+	bw.WriteString("</attributes>\n<datasets>\n")
+	for _, d := range k.datasets {
+		fmt.Fprintf(bw, "\t<dataset>%s</dataset>\n", xmlText(d.name))
+	}
+	bw.WriteString("</datasets>\n")
+	var node func(taxid, depth int)
+	node = func(taxid, depth int) {
+		indent := strings.Repeat("\t", depth)
+		name, rank := kronaUnassigned, ""
+		if taxid != -1 {
+			tn := taxDB.Node(taxid)
+			name, rank = string(tn.Name), string(tn.Taxon)
+		}
+		fmt.Fprintf(bw, "%s<node name=\"%s\">\n%s\t<magnitude>", indent, xmlText(name), indent)
+		for _, m := range mags[taxid] {
+			fmt.Fprintf(bw, "<val>%s</val>", formatMagnitude(m))
+		}
+		bw.WriteString("</magnitude>\n")
+		if withCount {
+			fmt.Fprintf(bw, "%s\t<count>", indent)
+			for _, c := range counts[taxid] {
+				fmt.Fprintf(bw, "<val>%d</val>", c)
+			}
+			bw.WriteString("</count>\n")
+		}
+		if rank != "" {
+			fmt.Fprintf(bw, "%s\t<rank><val>%s</val></rank>\n", indent, xmlText(rank))
+		}
+		if taxid != -1 {
+			fmt.Fprintf(bw, "%s\t<taxid><val>%d</val></taxid>\n", indent, taxid)
+		}
+		children := make([]int, 0)
+		if taxid != -1 {
+			for _, child := range taxDB.Children(taxid) {
+				if mags[child] != nil {
+					children = append(children, child)
+				}
+			}
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if ti, tj := total(children[i]), total(children[j]); ti != tj {
+				return ti > tj
+			}
+			return children[i] < children[j]
+		})
+		if taxid == rootTaxid && mags[-1] != nil {
+			children = append(children, -1)
+		}
+		for _, child := range children {
+			node(child, depth+1)
+		}
+		fmt.Fprintf(bw, "%s</node>\n", indent)
+	}
+	if mags[rootTaxid] != nil {
+		node(rootTaxid, 0)
+	}
+	bw.WriteString("</krona>\n")
+	return bw.Flush()
+}
+
+// kronaMain runs the krona subcommand with its arguments
+func kronaMain(args []string) {
+	fs := flag.NewFlagSet(kronaCommand, flag.ExitOnError)
+	nodes := fs.String("nodes", "nodes.dmp", "nodes.dmp file of taxonomy")
+	names := fs.String("names", "names.dmp", "names.dmp file of taxonomy")
+	format := fs.String("format", "xml", "Krona input written: \"xml\" (for ktImportXML, a chart with all the samples) or \"text\" (for ktImportText, a file per sample)")
+	out := fs.String("out", xopen.Stdio, "Output file, compressed if it ends in .gz, .bz2 or .zst (- for the standard output). With -format text and several samples, %s is replaced by the sample name")
+	magnitudesPath := fs.String("magnitudes", "", "Tab separated query names and magnitudes (e.g. read counts or coverages) that weight the queries, 1 for the ones not in it [optional]")
+	tagged := fs.Bool("tagged", false, "The results in the legacy format start with the sample column (several samples written to the same output)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "blast2lca %s writes the input of Krona from the results of blast2lca (any -outformat)\n", kronaCommand)
+		fmt.Fprintf(os.Stderr, "Usage: blast2lca %s [options] results...\n\n", kronaCommand)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		fmt.Fprintf(os.Stderr, "\nA results file (- for the standard input) or several ones are mandatory\n\n")
+		os.Exit(1)
+	}
+	if *format != "xml" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "ERROR: -format must be \"xml\" or \"text\"\n")
+		os.Exit(1)
+	}
+
+	var magnitudes map[string]float64
+	if *magnitudesPath != "" {
+		var err error
+		if magnitudes, err = readMagnitudes(*magnitudesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR : Unable to read the magnitudes: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	taxDB, err := taxonomy.New(*nodes, *names, "", false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR : Impossible to get a valid Taxonomy: %s\n", err)
+		os.Exit(1)
+	}
+
+	// The results of each file are a sample, unless they have the sample column
+	data := &kronaData{byName: make(map[string]*kronaDataset)}
+	reader := &resultReader{taxDB: taxDB, tagged: *tagged}
+	nqueries := 0
+	for _, path := range fs.Args() {
+		name := sampleName(path)
+		err := reader.read(path, func(sample string, query []byte, taxid int) {
+			if sample == "" {
+				sample = name
+			}
+			m, ok := magnitudes[string(query)]
+			if !ok {
+				m = 1
+			}
+			data.dataset(sample).add(taxid, m)
+			nqueries++
+		})
+		if err != nil {
+			log.Fatalf("ERROR: Unable to read %s: %s\n", path, err)
+		}
+	}
+	if reader.unknown > 0 {
+		log.Printf("WARNING: %d queries assigned to taxa that are not in the taxonomy, taken as unassigned\n", reader.unknown)
+	}
+	if len(reader.ambiguous) > 0 {
+		ambiguous := make([]string, 0, len(reader.ambiguous))
+		for name := range reader.ambiguous {
+			ambiguous = append(ambiguous, name)
+		}
+		sort.Strings(ambiguous)
+		log.Printf("WARNING: Names of several taxa of the same rank, the lowest taxid is used (write the results with -outformat tsv or jsonl to avoid it): %s\n", strings.Join(ambiguous, ", "))
+	}
+
+	write := func(path string, fn func(w io.Writer) error) {
+		w, err := xopen.Create(path)
+		if err != nil {
+			log.Fatalf("ERROR: Unable to create file %s: %s\n", path, err)
+		}
+		if err := fn(w); err != nil {
+			w.Close()
+			log.Fatalf("ERROR: Unable to write %s: %s\n", path, err)
+		}
+		if err := w.Close(); err != nil {
+			log.Fatalf("ERROR: Unable to write %s: %s\n", path, err)
+		}
+	}
+	if *format == "xml" {
+		write(*out, func(w io.Writer) error { return writeKronaXML(w, data, taxDB, magnitudes != nil) })
+	} else {
+		if len(data.datasets) > 1 && !strings.Contains(*out, "%s") {
+			log.Fatalf("ERROR: -out must contain %%s (replaced by the sample name) to write the text of several samples\n")
+		}
+		for _, d := range data.datasets {
+			write(strings.Replace(*out, "%s", d.name, -1), func(w io.Writer) error { return writeKronaText(w, d, taxDB) })
+		}
+	}
+	log.Printf("%d queries of %d samples written for Krona\n", nqueries, len(data.datasets))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTemp writes content to a file of a temporary directory and returns its path
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResultReader(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name      string
+		input     string
+		tagged    bool
+		want      []string // sample:query:taxid
+		unknown   int64
+		ambiguous []string
+	}{
+		{
+			name: "jsonl",
+			input: `{"sample":"s1","query":"q1","status":"assigned","taxid":562}` + "\n" +
+				`{"query":"q2","status":"no_hits","taxid":0}` + "\n" +
+				`{"query":"q3","status":"assigned","taxid":999}` + "\n",
+			want:    []string{"s1:q1:562", ":q2:-1", ":q3:-1"},
+			unknown: 1,
+		},
+		{
+			name:  "tsv",
+			input: "sample\tquery\tstatus\ttaxid\tname\ns1\tq1\tassigned\t83333\tEscherichia coli K-12\ns2\tq2\tno_hits\t0\t\n",
+			want:  []string{"s1:q1:83333", "s2:q2:-1"},
+		},
+		{
+			name: "legacy",
+			input: "q1\tEscherichia coli\tspecies\tassigned\n" +
+				"q2\t\t\tno_hits\n" +
+				"q3\tBacillus\tgenus\tassigned\n" +
+				"q4\tBacteria\tphylum\tassigned\n",
+			want:      []string{":q1:562", ":q2:-1", ":q3:1386", ":q4:-1"},
+			unknown:   1,
+			ambiguous: []string{"Bacillus"},
+		},
+		{
+			name:   "tagged legacy",
+			input:  "s1\tq1\tFirmicutes\tphylum\tassigned\ns2\tq2\t\t\tno_hits\n",
+			tagged: true,
+			want:   []string{"s1:q1:1239", "s2:q2:-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTemp(t, "results", tt.input)
+			reader := &resultReader{taxDB: taxDB, tagged: tt.tagged}
+			var got []string
+			err := reader.read(path, func(sample string, query []byte, taxid int) {
+				got = append(got, fmt.Sprintf("%s:%s:%d", sample, query, taxid))
+			})
+			if err != nil {
+				t.Fatalf("read() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read() = %q, want %q", got, tt.want)
+			}
+			if reader.unknown != tt.unknown {
+				t.Errorf("read() found %d unknown taxa, want %d", reader.unknown, tt.unknown)
+			}
+			var ambiguous []string
+			for name := range reader.ambiguous {
+				ambiguous = append(ambiguous, name)
+			}
+			if !reflect.DeepEqual(ambiguous, tt.ambiguous) {
+				t.Errorf("read() ambiguous names = %q, want %q", ambiguous, tt.ambiguous)
+			}
+		})
+	}
+}
+
+func TestResultReaderErrors(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"jsonl", `{"query":"q1","taxid":562}` + "\n{\"query\":\n", ":2: "},
+		{"tsv field count", "query\ttaxid\nq1\t562\nq2\n", ":3: expected 2 tab separated fields as in the header, found 1"},
+		{"tsv taxid", "query\ttaxid\nq1\tx\n", ":2: invalid taxid x"},
+		{"legacy", "q1\tBacteria\n", ":1: expected query, name and rank"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTemp(t, "results", tt.input)
+			reader := &resultReader{taxDB: taxDB}
+			err := reader.read(path, func(string, []byte, int) {})
+			if err == nil || !strings.Contains(err.Error(), path+tt.want) {
+				t.Errorf("read() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMagnitudes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]float64
+		err   string
+	}{
+		{
+			name:  "magnitudes",
+			input: "# query\tcount\nq1\t10\n\nq2\t2.5\textra\n q3 \t 0 \n",
+			want:  map[string]float64{"q1": 10, "q2": 2.5, "q3": 0},
+		},
+		{name: "without magnitude", input: "q1\t10\nq2\n", err: ":2: expected query name and magnitude"},
+		{name: "negative", input: "q1\t-1\n", err: ":1: invalid magnitude -1"},
+		{name: "not a number", input: "q1\tten\n", err: ":1: invalid magnitude ten"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readMagnitudes(writeTemp(t, "magnitudes", tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("readMagnitudes() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMagnitudes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readMagnitudes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteKronaText(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	data := &kronaData{byName: make(map[string]*kronaDataset)}
+	d := data.dataset("s1")
+	d.add(562, 1)
+	d.add(562, 1)
+	d.add(83333, 0.5)
+	d.add(-1, 1)
+	d.add(rootTaxid, 1)
+	var out bytes.Buffer
+	if err := writeKronaText(&out, d, taxDB); err != nil {
+		t.Fatalf("writeKronaText() error = %v", err)
+	}
+	want := "1\n" +
+		"1\tUnassigned\n" +
+		"2\tcellular organisms\tBacteria\tProteobacteria\tEscherichia coli\n" +
+		"0.5\tcellular organisms\tBacteria\tProteobacteria\tEscherichia coli\tEscherichia coli K-12\n"
+	if got := out.String(); got != want {
+		t.Errorf("writeKronaText() = %q, want %q", got, want)
+	}
+}
+
+// kronaNode is a node of the KronaXML written by writeKronaXML
+type kronaNode struct {
+	Name      string      `xml:"name,attr"`
+	Magnitude []string    `xml:"magnitude>val"`
+	Count     []string    `xml:"count>val"`
+	Rank      string      `xml:"rank>val"`
+	Taxid     string      `xml:"taxid>val"`
+	Nodes     []kronaNode `xml:"node"`
+}
+
+// flatten returns a line per node, indented by its depth: name, magnitudes, counts, rank and taxid
+func (n kronaNode) flatten(depth int) []string {
+	lines := []string{fmt.Sprintf("%s%s %s %s %s %s", strings.Repeat(" ", depth), n.Name,
+		strings.Join(n.Magnitude, ","), strings.Join(n.Count, ","), n.Rank, n.Taxid)}
+	for _, child := range n.Nodes {
+		lines = append(lines, child.flatten(depth+1)...)
+	}
+	return lines
+}
+
+func TestWriteKronaXML(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	data := &kronaData{byName: make(map[string]*kronaDataset)}
+	data.dataset("s1").add(562, 2)
+	data.dataset("s<2>").add(1239, 1)
+	data.dataset("s1").add(-1, 1)
+	tests := []struct {
+		withCount bool
+		want      []string
+	}{
+		{
+			withCount: true,
+			want: []string{ // The taxonomy has no rank for the root
+				"root 3,1 2,1  1",
+				" cellular organisms 2,1 1,1 no rank 131567",
+				"  Bacteria 2,1 1,1 superkingdom 2",
+				"   Proteobacteria 2,0 1,0 phylum 1224",
+				"    Escherichia coli 2,0 1,0 species 562",
+				"   Firmicutes 0,1 0,1 phylum 1239",
+				" Unassigned 1,0 1,0  ",
+			},
+		},
+		{
+			want: []string{
+				"root 3,1   1",
+				" cellular organisms 2,1  no rank 131567",
+				"  Bacteria 2,1  superkingdom 2",
+				"   Proteobacteria 2,0  phylum 1224",
+				"    Escherichia coli 2,0  species 562",
+				"   Firmicutes 0,1  phylum 1239",
+				" Unassigned 1,0   ",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("withCount=%v", tt.withCount), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeKronaXML(&out, data, taxDB, tt.withCount); err != nil {
+				t.Fatalf("writeKronaXML() error = %v", err)
+			}
+			var chart struct {
+				Datasets []string  `xml:"datasets>dataset"`
+				Root     kronaNode `xml:"node"`
+			}
+			if err := xml.Unmarshal(out.Bytes(), &chart); err != nil {
+				t.Fatalf("writeKronaXML() wrote invalid XML: %v\n%s", err, out.String())
+			}
+			if want := []string{"s1", "s<2>"}; !reflect.DeepEqual(chart.Datasets, want) {
+				t.Errorf("writeKronaXML() datasets = %q, want %q", chart.Datasets, want)
+			}
+			if got := chart.Root.flatten(0); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeKronaXML() nodes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
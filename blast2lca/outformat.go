@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+// outFormat formats the results of the queries in one of the output formats (-outformat)
+type outFormat interface {
+	// header returns the first line of the output ("" if there is none), with the sample column if tagged is set
+	header(levs [][]byte, tagged bool) string
+	// line returns the output line of a query, with the sample column (or field) if tag is not empty
+	line(taxDB *taxonomy.Taxonomy, rec *blastm8.QueryRes, levs [][]byte, tag string) string
+}
+
+// outFormatNames are the names of the output formats
+var outFormatNames = []string{"legacy", "tsv", "jsonl"}
+
+// defaultColumns are the default columns of the tsv output format
+const defaultColumns = "query,status,taxid,name,rank,levels,lineage,confidence"
+
+// newOutFormat returns the output format with the given name. columns are the comma separated columns of the tsv format
+func newOutFormat(name, columns string) (outFormat, error) {
+	switch name {
+	case "legacy":
+		return legacyFormat{}, nil
+	case "tsv":
+		return newTSVFormat(columns)
+	case "jsonl":
+		return jsonFormat{}, nil
+	}
+	return nil, fmt.Errorf("Unknown output format %s (must be %s)", name, strings.Join(outFormatNames, ", "))
+}
+
+// lineageNode is a taxon of the lineage of an assignment
+type lineageNode struct {
+	Taxid int    `json:"taxid"`
+	Name  string `json:"name"`
+	Rank  string `json:"rank"`
+}
+
+// assignment has the fields of the result of a query used by the output formats
+type assignment struct {
+	rec        *blastm8.QueryRes
+	taxid      int // 0 for unassigned queries
+	name, rank string
+	atLevs     [][]byte
+	lineage    []lineageNode // From the highest taxon to the assigned one, without the root
+}
+
+// newAssignment describes the result of a query. The lineage is only filled if withLineage is set
+func newAssignment(taxDB *taxonomy.Taxonomy, rec *blastm8.QueryRes, levs [][]byte, withLineage bool) *assignment {
+	a := &assignment{rec: rec, lineage: []lineageNode{}}
+	a.atLevs = atLevels(taxDB, rec.Taxid, levs)
+	if rec.Taxid == -1 {
+		return a
+	}
+	node := taxDB.Node(rec.Taxid)
+	a.taxid, a.name, a.rank = rec.Taxid, string(node.Name), string(node.Taxon)
+	if withLineage {
+		lineage := taxDB.Lineage(rec.Taxid)
+		for i := len(lineage) - 1; i >= 0; i-- {
+			node := taxDB.Node(lineage[i])
+			a.lineage = append(a.lineage, lineageNode{Taxid: node.Taxid, Name: string(node.Name), Rank: string(node.Taxon)})
+		}
+	}
+	return a
+}
+
+// round3 rounds the fractions of the output to 3 decimals
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+// legacyFormat is the original output: query, name, rank, the taxa at the levels (only with -levels) and
+// the statistics (only with -stats), followed by the status
+type legacyFormat struct{}
+
+func (legacyFormat) header(levs [][]byte, tagged bool) string {
+	return ""
+}
+
+func (legacyFormat) line(taxDB *taxonomy.Taxonomy, rec *blastm8.QueryRes, levs [][]byte, tag string) string {
+	name, rank, allLevs := describe(taxDB, rec.Taxid, levs)
+	msg := fmt.Sprintf("%s\t%s\t%s", rec.Query, name, rank)
+	if len(levs) > 0 && len(levs[0]) > 0 {
+		msg += fmt.Sprintf("\t%s", allLevs)
+	}
+	if statsflag {
+		msg += fmt.Sprintf("\t%s", rec.Stats)
+	}
+	msg += "\t" + rec.Status.String()
+	if tag != "" {
+		msg = tag + "\t" + msg
+	}
+	return msg + "\n"
+}
+
+// tsvColumns are the columns of the tsv format. The levels column is expanded to a column for each level of -levels
+var tsvColumns = map[string]func(a *assignment) string{
+	"query":  func(a *assignment) string { return string(a.rec.Query) },
+	"status": func(a *assignment) string { return a.rec.Status.String() },
+	"taxid":  func(a *assignment) string { return strconv.Itoa(a.taxid) },
+	"name":   func(a *assignment) string { return a.name },
+	"rank":   func(a *assignment) string { return a.rank },
+	"lineage": func(a *assignment) string {
+		return joinLineage(a.lineage, func(n lineageNode) string { return n.Name })
+	},
+	"lineage_taxids": func(a *assignment) string {
+		return joinLineage(a.lineage, func(n lineageNode) string { return strconv.Itoa(n.Taxid) })
+	},
+	"lineage_ranks": func(a *assignment) string {
+		return joinLineage(a.lineage, func(n lineageNode) string { return n.Rank })
+	},
+	"hits":                 func(a *assignment) string { return strconv.Itoa(a.rec.Stats.NHits) },
+	"hits_mapped":          func(a *assignment) string { return strconv.Itoa(a.rec.Stats.Mapped) },
+	"hits_unmapped":        func(a *assignment) string { return strconv.Itoa(a.rec.Stats.Unmapped) },
+	"hits_not_in_taxonomy": func(a *assignment) string { return strconv.Itoa(a.rec.Stats.NotInTax) },
+	"hits_excluded":        func(a *assignment) string { return strconv.Itoa(a.rec.Stats.Excluded) },
+	"childfrac":            func(a *assignment) string { return fmt.Sprintf("%.3f", a.rec.Stats.ChildFrac) },
+	"best_bitscore":        func(a *assignment) string { return fmt.Sprintf("%.1f", a.rec.Stats.BestBitsc) },
+	"best_ident":           func(a *assignment) string { return fmt.Sprintf("%.2f", a.rec.Stats.BestIdent) },
+	"confidence":           func(a *assignment) string { return fmt.Sprintf("%.3f", a.rec.Stats.Conf) },
+}
+
+func joinLineage(lineage []lineageNode, field func(lineageNode) string) string {
+	fields := make([]string, len(lineage))
+	for i, n := range lineage {
+		fields[i] = field(n)
+	}
+	return strings.Join(fields, ";")
+}
+
+// tsvFormat is a tab separated table with a header line and the selected columns
+type tsvFormat struct {
+	columns     []string
+	withLineage bool
+}
+
+func newTSVFormat(columns string) (*tsvFormat, error) {
+	f := &tsvFormat{}
+	for _, col := range strings.Split(columns, ",") {
+		col = strings.TrimSpace(col)
+		if _, ok := tsvColumns[col]; !ok && col != "levels" {
+			return nil, fmt.Errorf("Unknown column %s", col)
+		}
+		f.columns = append(f.columns, col)
+		f.withLineage = f.withLineage || strings.HasPrefix(col, "lineage")
+	}
+	return f, nil
+}
+
+func (f *tsvFormat) header(levs [][]byte, tagged bool) string {
+	header := make([]string, 0, len(f.columns)+len(levs)+1)
+	if tagged {
+		header = append(header, "sample")
+	}
+	for _, col := range f.columns {
+		if col != "levels" {
+			header = append(header, col)
+			continue
+		}
+		for _, lev := range levs {
+			if len(lev) > 0 {
+				header = append(header, string(lev))
+			}
+		}
+	}
+	return strings.Join(header, "\t") + "\n"
+}
+
+func (f *tsvFormat) line(taxDB *taxonomy.Taxonomy, rec *blastm8.QueryRes, levs [][]byte, tag string) string {
+	a := newAssignment(taxDB, rec, levs, f.withLineage)
+	fields := make([]string, 0, len(f.columns)+len(levs)+1)
+	if tag != "" {
+		fields = append(fields, tag)
+	}
+	for _, col := range f.columns {
+		if col != "levels" {
+			fields = append(fields, tsvColumns[col](a))
+			continue
+		}
+		for _, atLev := range a.atLevs {
+			fields = append(fields, string(atLev))
+		}
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+// jsonFormat writes a JSON object per line
+type jsonFormat struct{}
+
+type jsonLevel struct {
+	Rank string `json:"rank"`
+	Name string `json:"name"`
+}
+
+type jsonStats struct {
+	Hits          int     `json:"hits"`
+	Mapped        int     `json:"mapped"`
+	Unmapped      int     `json:"unmapped"`
+	NotInTaxonomy int     `json:"not_in_taxonomy"`
+	Excluded      int     `json:"excluded"`
+	ChildFrac     float64 `json:"childfrac"`
+	BestBitscore  float64 `json:"best_bitscore"`
+	BestIdent     float64 `json:"best_ident"`
+	Confidence    float64 `json:"confidence"`
+}
+
+type jsonResult struct {
+	Sample  string        `json:"sample,omitempty"`
+	Query   string        `json:"query"`
+	Status  string        `json:"status"`
+	Taxid   int           `json:"taxid"`
+	Name    string        `json:"name"`
+	Rank    string        `json:"rank"`
+	Lineage []lineageNode `json:"lineage"`
+	Levels  []jsonLevel   `json:"levels,omitempty"`
+	Stats   jsonStats     `json:"stats"`
+}
+
+func (jsonFormat) header(levs [][]byte, tagged bool) string {
+	return ""
+}
+
+func (jsonFormat) line(taxDB *taxonomy.Taxonomy, rec *blastm8.QueryRes, levs [][]byte, tag string) string {
+	a := newAssignment(taxDB, rec, levs, true)
+	res := jsonResult{
+		Sample:  tag,
+		Query:   string(rec.Query),
+		Status:  rec.Status.String(),
+		Taxid:   a.taxid,
+		Name:    a.name,
+		Rank:    a.rank,
+		Lineage: a.lineage,
+		Stats: jsonStats{
+			Hits:          rec.Stats.NHits,
+			Mapped:        rec.Stats.Mapped,
+			Unmapped:      rec.Stats.Unmapped,
+			NotInTaxonomy: rec.Stats.NotInTax,
+			Excluded:      rec.Stats.Excluded,
+			ChildFrac:     round3(rec.Stats.ChildFrac),
+			BestBitscore:  rec.Stats.BestBitsc,
+			BestIdent:     rec.Stats.BestIdent,
+			Confidence:    round3(rec.Stats.Conf),
+		},
+	}
+	for i, atLev := range a.atLevs {
+		res.Levels = append(res.Levels, jsonLevel{Rank: string(levs[i]), Name: string(atLev)})
+	}
+	line, err := json.Marshal(&res)
+	if err != nil { // Only possible with infinite scores
+		log.Printf("WARNING: Unable to write query %s as JSON: %s\n", rec.Query, err)
+		return ""
+	}
+	return string(line) + "\n"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+)
+
+// pipeline runs the stages of the program in their own goroutines and collects the first error.
+// After the first error the quit channel is closed so the stages can stop
+type pipeline struct {
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+	quit chan struct{}
+}
+
+func newPipeline() *pipeline {
+	return &pipeline{quit: make(chan struct{})}
+}
+
+// Go runs stage in a new goroutine
+func (p *pipeline) Go(stage func() error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := stage(); err != nil {
+			p.once.Do(func() {
+				p.err = err
+				close(p.quit)
+			})
+		}
+	}()
+}
+
+// Wait waits until all the stages are done and returns the first error.
+// The stages must return soon after quit is closed
+func (p *pipeline) Wait() error {
+	p.wg.Wait()
+	return p.err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/xopen"
+)
+
+// querySet is the set of the names of the queries written to the output
+type querySet map[string]struct{}
+
+func (s querySet) add(name []byte) {
+	s[string(name)] = struct{}{}
+}
+
+// has tells if name is in the set
+func (s querySet) has(name []byte) bool {
+	_, ok := s[string(name)]
+	return ok
+}
+
+// readQueryNames calls fn with the name of each query of a FASTA, FASTQ or list (one name per line) file.
+// The name is the first word of the header (or of the line) and is only valid during the call.
+// The format is taken from the first character of the file (> for FASTA and @ for FASTQ)
+func readQueryNames(path string, fn func(name []byte) error) error {
+	buf, err := xopen.Open(path)
+	if err != nil {
+		return err
+	}
+	defer buf.Close()
+	format := byte(0)
+	if head, _ := buf.Peek(1); len(head) > 0 && (head[0] == '>' || head[0] == '@') {
+		format = head[0]
+	}
+	for nline := 0; ; nline++ {
+		line, err := buf.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			line = append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				var more []byte
+				more, err = buf.ReadSlice('\n')
+				line = append(line, more...)
+			}
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		var name []byte
+		switch format {
+		case '>': // Header lines
+			if len(line) > 0 && line[0] == '>' {
+				name = line[1:]
+			}
+		case '@': // First line of each record of 4 lines
+			if nline%4 == 0 && len(line) > 0 && line[0] == '@' {
+				name = line[1:]
+			}
+		default:
+			if len(line) > 0 && line[0] != '#' {
+				name = line
+			}
+		}
+		if sp := bytes.IndexAny(name, " \t"); sp >= 0 {
+			name = name[:sp]
+		}
+		if len(name) > 0 {
+			if err := fn(name); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// writeMissing writes the queries of the queries file missing from col.written as queries without hits,
+// prefixed with the tag column if it is not empty, and adds them to the other collectors. Returns their number.
+// With -paired, the queries are the fragments of the reads, repeated consecutive fragments are written once
+func writeMissing(path string, w io.Writer, tag string, col *collectors, levs [][]byte) (int, error) {
+	written := col.written
+	others := &collectors{contigs: col.contigs, report: col.report}
+	var prev []byte
+	missing := 0
+	err := readQueryNames(path, func(name []byte) error {
+		if pairedflag != "" {
+			name, _ = blastm8.MateName(name)
+			if bytes.Equal(name, prev) {
+				return nil
+			}
+			prev = append(prev[:0], name...)
+		}
+		if written.has(name) {
+			return nil
+		}
+		missing++
+		rec := &blastm8.QueryRes{Query: append(blastm8.Header(nil), name...), Taxid: -1, Status: blastm8.NoHits}
+		others.add(rec)
+		_, err := io.WriteString(w, outFmt.line(nil, rec, levs, tag))
+		return err
+	})
+	return missing, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+// rootTaxid is the taxid of the root of the NCBI taxonomy
+const rootTaxid = 1
+
+// reportRanks are the rank codes of the Kraken report. Taxa of other ranks get the code of their closest ranked
+// ancestor followed by their distance to it (e.g. S1 for a strain below a species)
+var reportRanks = map[string]string{
+	"superkingdom": "D",
+	"domain":       "D",
+	"kingdom":      "K",
+	"phylum":       "P",
+	"class":        "C",
+	"order":        "O",
+	"family":       "F",
+	"genus":        "G",
+	"species":      "S",
+}
+
+// cladeReport counts the queries assigned to each taxon for the Kraken-style report (-report)
+type cladeReport struct {
+	direct       map[int]int64 // Queries assigned to each taxid
+	unclassified int64
+}
+
+func newCladeReport() *cladeReport {
+	return &cladeReport{direct: make(map[int]int64)}
+}
+
+func (r *cladeReport) add(rec *blastm8.QueryRes) {
+	if rec.Taxid == -1 {
+		r.unclassified++
+		return
+	}
+	r.direct[rec.Taxid]++
+}
+
+// write writes the report in the format of Kraken: percentage of the queries in the clade, queries in the clade,
+// queries assigned to the taxon, rank code, taxid and name indented by its depth. The taxa are walked from the root
+// with the children sorted by clade size. Taxa without queries are only written if zeros is set
+func (r *cladeReport) write(w io.Writer, taxDB *taxonomy.Taxonomy, zeros bool) error {
+	clade := make(map[int]int64, 4*len(r.direct))
+	total := r.unclassified
+	for taxid, n := range r.direct {
+		total += n
+		for _, t := range taxDB.Lineage(taxid) { // Without the root
+			clade[t] += n
+		}
+		clade[rootTaxid] += n
+	}
+	bw := bufio.NewWriter(w)
+	line := func(count, direct int64, rank string, taxid, depth int, name string) {
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(count) / float64(total)
+		}
+		fmt.Fprintf(bw, "%6.2f\t%d\t%d\t%s\t%d\t%s%s\n", pct, count, direct, rank, taxid, strings.Repeat("  ", depth), name)
+	}
+	line(r.unclassified, r.unclassified, "U", 0, 0, "unclassified")
+	var walk func(taxid, depth int, code string, steps int)
+	walk = func(taxid, depth int, code string, steps int) {
+		node := taxDB.Node(taxid)
+		if c, ok := reportRanks[string(node.Taxon)]; ok {
+			code, steps = c, 0
+		}
+		rank := code
+		if steps > 0 {
+			rank = fmt.Sprintf("%s%d", code, steps)
+		}
+		line(clade[taxid], r.direct[taxid], rank, taxid, depth, string(node.Name))
+		children := taxDB.Children(taxid)
+		if !zeros {
+			kept := children[:0]
+			for _, child := range children {
+				if clade[child] > 0 {
+					kept = append(kept, child)
+				}
+			}
+			children = kept
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if clade[children[i]] != clade[children[j]] {
+				return clade[children[i]] > clade[children[j]]
+			}
+			return children[i] < children[j]
+		})
+		for _, child := range children {
+			walk(child, depth+1, code, steps+1)
+		}
+	}
+	if taxDB.Node(rootTaxid) != nil && (zeros || clade[rootTaxid] > 0) {
+		walk(rootTaxid, 0, "R", 0)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+// testNodes and testNames are a small taxonomy in the format of the NCBI dump files
+var testNodes = [][3]string{ // Taxid, parent and rank
+	{"1", "1", "no rank"},
+	{"131567", "1", "no rank"},
+	{"2", "131567", "superkingdom"},
+	{"1224", "2", "phylum"},
+	{"562", "1224", "species"},
+	{"83333", "562", "no rank"},
+	{"1239", "2", "phylum"},
+	{"1386", "1239", "genus"},
+	{"2759", "131567", "superkingdom"},
+	{"55087", "2759", "genus"},
+}
+
+var testNames = [][2]string{ // Taxid and scientific name
+	{"1", "root"},
+	{"131567", "cellular organisms"},
+	{"2", "Bacteria"},
+	{"1224", "Proteobacteria"},
+	{"562", "Escherichia coli"},
+	{"83333", "Escherichia coli K-12"},
+	{"1239", "Firmicutes"},
+	{"1386", "Bacillus"},
+	{"2759", "Eukaryota"},
+	{"55087", "Bacillus"}, // Stick insects, a homonym of the same rank
+}
+
+// testTaxonomy loads the test taxonomy without a GI dictionary
+func testTaxonomy(t *testing.T) *taxonomy.Taxonomy {
+	t.Helper()
+	dir := t.TempDir()
+	var nodes, names strings.Builder
+	for _, n := range testNodes {
+		nodes.WriteString(n[0] + "\t|\t" + n[1] + "\t|\t" + n[2] + "\t|\tXX\t|\n")
+	}
+	for _, n := range testNames {
+		names.WriteString(n[0] + "\t|\t" + n[1] + "\t|\t\t|\tscientific name\t|\n")
+	}
+	nodesfn, namesfn := filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp")
+	if err := os.WriteFile(nodesfn, []byte(nodes.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(namesfn, []byte(names.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	taxDB, err := taxonomy.New(nodesfn, namesfn, "", false)
+	if err != nil {
+		t.Fatalf("taxonomy.New() error = %v", err)
+	}
+	return taxDB
+}
+
+func TestCladeReport(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name   string
+		taxids []int // Assignments of the queries, -1 for the unassigned ones
+		zeros  bool
+		want   string
+	}{
+		{
+			name:   "clades",
+			taxids: []int{562, 83333, -1, 1239, 562, 1},
+			want: " 16.67\t1\t1\tU\t0\tunclassified\n" +
+				" 83.33\t5\t1\tR\t1\troot\n" +
+				" 66.67\t4\t0\tR1\t131567\t  cellular organisms\n" +
+				" 66.67\t4\t0\tD\t2\t    Bacteria\n" +
+				" 50.00\t3\t0\tP\t1224\t      Proteobacteria\n" +
+				" 50.00\t3\t2\tS\t562\t        Escherichia coli\n" +
+				" 16.67\t1\t1\tS1\t83333\t          Escherichia coli K-12\n" +
+				" 16.67\t1\t1\tP\t1239\t      Firmicutes\n",
+		},
+		{
+			name:   "zeros",
+			taxids: []int{1239},
+			zeros:  true,
+			want: "  0.00\t0\t0\tU\t0\tunclassified\n" +
+				"100.00\t1\t0\tR\t1\troot\n" +
+				"100.00\t1\t0\tR1\t131567\t  cellular organisms\n" +
+				"100.00\t1\t0\tD\t2\t    Bacteria\n" +
+				"100.00\t1\t1\tP\t1239\t      Firmicutes\n" +
+				"  0.00\t0\t0\tG\t1386\t        Bacillus\n" +
+				"  0.00\t0\t0\tP\t1224\t      Proteobacteria\n" +
+				"  0.00\t0\t0\tS\t562\t        Escherichia coli\n" +
+				"  0.00\t0\t0\tS1\t83333\t          Escherichia coli K-12\n" +
+				"  0.00\t0\t0\tD\t2759\t    Eukaryota\n" +
+				"  0.00\t0\t0\tG\t55087\t      Bacillus\n",
+		},
+		{
+			name:   "unclassified only",
+			taxids: []int{-1, -1},
+			want:   "100.00\t2\t2\tU\t0\tunclassified\n",
+		},
+		{
+			name: "no queries",
+			want: "  0.00\t0\t0\tU\t0\tunclassified\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newCladeReport()
+			for _, taxid := range tt.taxids {
+				report.add(&blastm8.QueryRes{Taxid: taxid})
+			}
+			var out bytes.Buffer
+			if err := report.write(&out, taxDB, tt.zeros); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("write() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
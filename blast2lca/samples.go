@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/emepyc/Blast2lca/xopen"
+)
+
+// sample is a blast file and the name used to tag its results
+type sample struct {
+	name    string
+	path    string
+	queries string // FASTA, FASTQ or list of the queries (see -queries)
+}
+
+// sampleName returns the name of the sample of a blast file: its base name without
+// the compression and the blast extensions (reads.m8.gz => reads)
+func sampleName(path string) string {
+	if path == xopen.Stdio {
+		return "stdin"
+	}
+	name := filepath.Base(path)
+	if xopen.FormatOf(name) != xopen.Plain {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if ext := filepath.Ext(name); ext != "" && ext != name {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// readManifest reads a manifest of samples with their names, the paths of their blast files and optionally the paths
+// of their queries files (tab separated, one per line, # for comments). Relative paths are relative to the manifest directory
+func readManifest(fname string) ([]sample, error) {
+	buf, err := xopen.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	dir := filepath.Dir(fname)
+	resolve := func(path string) string {
+		if path != xopen.Stdio && path != "" && !filepath.IsAbs(path) {
+			return filepath.Join(dir, path)
+		}
+		return path
+	}
+	samples := make([]sample, 0)
+	for nline := 1; ; nline++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l := bytes.TrimSpace(line); len(l) > 0 && l[0] != '#' {
+			parts := bytes.Split(l, []byte{'\t'})
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("%s:%d: expected sample name and blast file", fname, nline)
+			}
+			smp := sample{name: string(bytes.TrimSpace(parts[0])), path: resolve(string(bytes.TrimSpace(parts[1])))}
+			if len(parts) > 2 {
+				smp.queries = resolve(string(bytes.TrimSpace(parts[2])))
+			}
+			samples = append(samples, smp)
+		}
+		if err == io.EOF {
+			return samples, nil
+		}
+	}
+}
+
+// checkSamples checks that the sample names are unique and that the standard input is read only once
+func checkSamples(samples []sample) error {
+	names := make(map[string]bool)
+	stdin := false
+	for _, s := range samples {
+		if names[s.name] {
+			return fmt.Errorf("Repeated sample name %s", s.name)
+		}
+		names[s.name] = true
+		if s.path == xopen.Stdio {
+			if stdin {
+				return errors.New("The standard input can only be used once")
+			}
+			stdin = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleName(t *testing.T) {
+	for path, want := range map[string]string{
+		"reads.m8":            "reads",
+		"dir/reads.m8.gz":     "reads",
+		"reads.blast.tsv.zst": "reads.blast",
+		"reads":               "reads",
+		"reads.gz":            "reads",
+		".m8":                 ".m8",
+		"-":                   "stdin",
+	} {
+		if got := sampleName(path); got != want {
+			t.Errorf("sampleName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		input string
+		want  []sample
+		err   string
+	}{
+		{
+			name: "samples",
+			input: "# name\tblast\tqueries\n" +
+				"s1\ts1.m8\n" +
+				"\n" +
+				" s2 \t /data/s2.m8.gz \tq/s2.fq\n" +
+				"s3\t-\t\n" +
+				"s4\tsub/s4.m8",
+			want: []sample{
+				{name: "s1", path: filepath.Join(dir, "s1.m8")},
+				{name: "s2", path: "/data/s2.m8.gz", queries: filepath.Join(dir, "q/s2.fq")},
+				{name: "s3", path: "-"},
+				{name: "s4", path: filepath.Join(dir, "sub/s4.m8")},
+			},
+		},
+		{name: "empty", input: "# no samples\n", want: []sample{}},
+		{name: "without blast file", input: "s1\ts1.m8\ns2\n", err: ":2: expected sample name and blast file"},
+		{name: "space separated", input: "s1 s1.m8\n", err: ":1: expected sample name and blast file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fname := filepath.Join(dir, "manifest.tsv")
+			if err := os.WriteFile(fname, []byte(tt.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readManifest(fname)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), fname+tt.err) {
+					t.Errorf("readManifest() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readManifest() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readManifest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []sample
+		want    string
+	}{
+		{"unique", []sample{{name: "s1", path: "a.m8"}, {name: "s2", path: "-"}}, ""},
+		{"repeated name", []sample{{name: "s1", path: "a.m8"}, {name: "s1", path: "b.m8"}}, "Repeated sample name s1"},
+		{"stdin twice", []sample{{name: "s1", path: "-"}, {name: "s2", path: "-"}}, "The standard input can only be used once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if err := checkSamples(tt.samples); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("checkSamples() error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplePaths(t *testing.T) {
+	oldOutdir, oldOutext, oldReport := outdirflag, outextflag, reportflag
+	defer func() { outdirflag, outextflag, reportflag = oldOutdir, oldOutext, oldReport }()
+	s := sample{name: "gut", path: "reads/gut.m8.gz"}
+	tests := []struct {
+		outdir, outext, report string
+		output, reportfn       string
+	}{
+		{"out", ".lca", "%s.report", "out/gut.lca", "gut.report"},
+		{"out/", ".lca.gz", "reports/%s/%s.txt", "out/gut.lca.gz", "reports/gut/gut.txt"},
+		{"", ".tsv", "all.report", "gut.tsv", "all.report"},
+	}
+	for _, tt := range tests {
+		outdirflag, outextflag, reportflag = tt.outdir, tt.outext, tt.report
+		if got := outputPath(s); got != tt.output {
+			t.Errorf("outputPath() with -outdir %q -outext %q = %q, want %q", tt.outdir, tt.outext, got, tt.output)
+		}
+		if got := reportPath(s); got != tt.reportfn {
+			t.Errorf("reportPath() with -report %q = %q, want %q", tt.report, got, tt.reportfn)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package blastm8
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+//Status tells why a query can't be assigned
+type Status int
+
+const (
+	Assigned         Status = iota
+	NoHits                  // No valid hits in the input
+	Filtered                // No hits passing the filters (bit score, minimum score, mate intersection...)
+	UnmappedSubjects        // The subjects of the hits can't be mapped to taxids
+	NotInTaxonomy           // The hits are mapped to taxids not present in the taxonomy
+	Excluded                // The hits are excluded by the exclusion/inclusion filter
+	Unassigned              // Any other reason
+	NumStatus               // Number of statuses
+)
+
+var statusCodes = [NumStatus]string{"assigned", "no_hits", "filtered", "unmapped_subjects", "not_in_taxonomy", "excluded", "unassigned"}
+
+//String returns the code of the status, as written in the output
+func (s Status) String() string {
+	if s < 0 || s >= NumStatus {
+		return statusCodes[Unassigned]
+	}
+	return statusCodes[s]
+}
+
+//unassigned sets the status of a query that can't be assigned from its hit counts.
+//If the hits are dropped for different reasons, the reason of most of them is given
+func (q *QueryRes) unassigned() {
+	q.Taxid = -1
+	switch {
+	case q.Parsed == 0:
+		q.Status = NoHits
+	case q.Stats.NHits == 0:
+		q.Status = Filtered
+	default:
+		q.Status = Unassigned
+		most := 0
+		for _, reason := range []struct {
+			status Status
+			n      int
+		}{{UnmappedSubjects, q.Stats.Unmapped}, {NotInTaxonomy, q.Stats.NotInTax}, {Excluded, q.Stats.Excluded}} {
+			if reason.n > most {
+				q.Status, most = reason.status, reason.n
+			}
+		}
+	}
+}
+
+//Stats collects the supporting evidence of the taxonomic assignment of a query
+type Stats struct {
+	NHits      int         // Hits considered (the ones passing the bit score filter)
+	Mapped     int         // Hits mapped to a taxid present in the taxonomy
+	Unmapped   int         // Hits whose GI can't be mapped to a taxid
+	NotInTax   int         // Hits mapped to a taxid not present in the taxonomy
+	Excluded   int         // Hits mapped to a taxid left out by the exclusion/inclusion filter
+	ChildTaxid int         // Child of the LCA supported by more hits (-1 if none)
+	ChildFrac  float64     // Fraction of considered hits agreeing at ChildTaxid
+	Childs     map[int]int // Number of hits below each child of the LCA
+	BestBitsc  float64
+	BestIdent  float64
+	Conf       float64 // Normalized confidence score [0-1]
+}
+
+//Dropped returns the number of considered hits that can't be used in the LCA
+func (s Stats) Dropped() int {
+	return s.Unmapped + s.NotInTax + s.Excluded
+}
+
+//String stringifies the stats as tab separated columns:
+//nhits, mapped, dropped, childfrac, bestbitsc, bestident and confidence
+func (s Stats) String() string {
+	return fmt.Sprintf("%d\t%d\t%d\t%.3f\t%.1f\t%.2f\t%.3f", s.NHits, s.Mapped, s.Dropped(), s.ChildFrac, s.BestBitsc, s.BestIdent, s.Conf)
+}
+
+//confidence combines the fraction of hits that agree with the amount of evidence.
+//A single agreeing hit scores 0.5 and n unanimous hits score n/(n+1)
+func confidence(agreeing, nhits int) float64 {
+	if nhits == 0 {
+		return 0
+	}
+	return (float64(agreeing) / float64(nhits)) * (1 - 1/float64(agreeing+1))
+}
+
+//mapHits maps the hits of the query to taxids using taxDB and returns the ones that can be used in the LCA
+//(i.e. present in the taxonomy and not excluded by filter). It also resets q.Stats and fills its hit counts
+func (q *QueryRes) mapHits(taxDB *taxonomy.Taxonomy, filter *taxonomy.Filter) Hits {
+	q.Taxid = -1
+	q.Status = Assigned
+	q.Stats = Stats{NHits: len(q.Hits), ChildTaxid: -1}
+	usable := make(Hits, 0, len(q.Hits))
+	for _, hit := range q.Hits {
+		if hit.bitsc > q.Stats.BestBitsc {
+			q.Stats.BestBitsc = hit.bitsc
+		}
+		if hit.ident > q.Stats.BestIdent {
+			q.Stats.BestIdent = hit.ident
+		}
+		taxid := hit.taxid // Given by the input, -1 if the subject has no GI
+		if taxid == 0 {
+			var err error
+			if taxid, err = taxDB.TaxidFromGi(hit.gi); err == taxonomy.ErrNoDict {
+				taxid = 0 // Every GI is unmapped, not worth a warning per hit
+			} else if err != nil || taxid <= 0 {
+				log.Printf("WARNING: Taxid can't be retrieved from %d -- Ignoring this record\n", hit.gi)
+				taxid = 0
+			} else {
+				hit.taxid = taxid
+			}
+		}
+		if taxid <= 0 {
+			q.Stats.Unmapped++
+			continue
+		}
+		if !taxDB.Has(taxid) {
+			q.Stats.NotInTax++
+			continue
+		}
+		if filter.Excluded(taxid) {
+			q.Stats.Excluded++
+			continue
+		}
+		q.Stats.Mapped++
+		usable = append(usable, hit)
+	}
+	return usable
+}
+
+//support fills the agreement fractions and the confidence of the assignment in q.Taxid with the given hits.
+//All of them are below the LCA, so the hits that agree are the ones in the clade of its best supported child
+//and the ones of the LCA itself, that agree with any child
+func (q *QueryRes) support(taxDB *taxonomy.Taxonomy, hits Hits) {
+	q.Stats.Childs = make(map[int]int)
+	atLCA := 0
+	for _, hit := range hits {
+		if hit.taxid == q.Taxid {
+			atLCA++
+			continue
+		}
+		lineage := taxDB.Lineage(hit.taxid)
+		for i, anc := range lineage {
+			if anc == q.Taxid {
+				q.Stats.Childs[lineage[i-1]]++
+				break
+			}
+		}
+		if q.Taxid == 1 && len(lineage) > 0 { // The root is not part of the lineages
+			q.Stats.Childs[lineage[len(lineage)-1]]++
+		}
+	}
+	bestChild := 0
+	for child, n := range q.Stats.Childs {
+		if n > bestChild || (n == bestChild && child < q.Stats.ChildTaxid) {
+			bestChild = n
+			q.Stats.ChildTaxid = child
+		}
+	}
+	if q.Stats.NHits > 0 {
+		q.Stats.ChildFrac = float64(bestChild) / float64(q.Stats.NHits)
+	}
+	q.Stats.Conf = confidence(atLCA+bestChild, q.Stats.NHits)
+}
+
+//Assign maps the hits of the query to taxids using taxDB and calculates the LCA of the ones not excluded by filter (may be nil).
+//The taxid of the LCA is stored in q.Taxid (-1 if it can't be calculated, with the reason in q.Status) and the supporting
+//evidence in q.Stats
+func (q *QueryRes) Assign(taxDB *taxonomy.Taxonomy, filter *taxonomy.Filter) error {
+	hits := q.mapHits(taxDB, filter)
+	taxids := make([]int, 0, len(hits))
+	for _, hit := range hits {
+		taxids = append(taxids, hit.taxid)
+	}
+	lcaNode, err := taxDB.LCA(taxids...)
+	if err != nil {
+		q.unassigned()
+		return err
+	}
+	q.Taxid = lcaNode.Taxid
+	q.support(taxDB, hits)
+	return nil
+}
@@ -0,0 +1,174 @@
+package blastm8
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+//testNodes is a small taxonomy: taxid, parent, rank and name
+var testNodes = []struct {
+	taxid, parent int
+	rank, name    string
+}{
+	{1, 1, "no rank", "root"},
+	{2, 1, "superkingdom", "Bacteria"},
+	{10, 2, "phylum", "Proteobacteria"},
+	{100, 10, "genus", "Escherichia"},
+	{101, 10, "genus", "Salmonella"},
+	{11, 2, "phylum", "Firmicutes"},
+	{3, 1, "superkingdom", "Eukaryota"},
+	{300, 3, "species", "Homo sapiens"},
+}
+
+//testTaxonomy loads testNodes without a GI dictionary
+func testTaxonomy(t testing.TB) *taxonomy.Taxonomy {
+	t.Helper()
+	var nodes, names strings.Builder
+	for _, n := range testNodes {
+		fmt.Fprintf(&nodes, "%d\t|\t%d\t|\t%s\t|\tXX\t|\n", n.taxid, n.parent, n.rank)
+		fmt.Fprintf(&names, "%d\t|\t%s\t|\t\t|\tscientific name\t|\n", n.taxid, n.name)
+	}
+	dir := t.TempDir()
+	nodesfn, namesfn := filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp")
+	if err := os.WriteFile(nodesfn, []byte(nodes.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(namesfn, []byte(names.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	taxDB, err := taxonomy.New(nodesfn, namesfn, "", false)
+	if err != nil {
+		t.Fatalf("taxonomy.New() error = %v", err)
+	}
+	return taxDB
+}
+
+//taxidHits returns a hit for each taxid, with decreasing bit scores
+func taxidHits(taxids ...int) Hits {
+	hits := make(Hits, len(taxids))
+	for i, taxid := range taxids {
+		hits[i] = &Hit{gi: -1, subject: Header(fmt.Sprintf("s%d", i)), taxid: taxid, bitsc: float64(100 - i)}
+	}
+	return hits
+}
+
+func TestAssignSupport(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name      string
+		taxids    []int
+		want      int
+		childFrac float64
+		conf      float64
+	}{
+		{name: "single hit", taxids: []int{100}, want: 100, conf: 0.5},
+		{name: "unanimous", taxids: []int{100, 100, 100}, want: 100, conf: 0.75},
+		{name: "split between two children", taxids: []int{100, 101}, want: 10, childFrac: 0.5, conf: 0.25},
+		{name: "majority child", taxids: []int{100, 100, 101}, want: 10, childFrac: 2.0 / 3, conf: 4.0 / 9},
+		{name: "hit of the LCA itself", taxids: []int{10, 100, 101}, want: 10, childFrac: 1.0 / 3, conf: 4.0 / 9},
+		{name: "root", taxids: []int{100, 101, 300}, want: 1, childFrac: 2.0 / 3, conf: 4.0 / 9},
+		{name: "taxa not in the taxonomy", taxids: []int{100, 999}, want: 100, conf: 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &QueryRes{Query: Header("q1"), Hits: taxidHits(tt.taxids...), Parsed: len(tt.taxids)}
+			if err := q.Assign(taxDB, nil); err != nil {
+				t.Fatalf("Assign() error = %v", err)
+			}
+			if q.Taxid != tt.want {
+				t.Errorf("Assign() taxid = %d, want %d", q.Taxid, tt.want)
+			}
+			if math.Abs(q.Stats.ChildFrac-tt.childFrac) > 1e-9 {
+				t.Errorf("Assign() ChildFrac = %.3f, want %.3f", q.Stats.ChildFrac, tt.childFrac)
+			}
+			if math.Abs(q.Stats.Conf-tt.conf) > 1e-9 {
+				t.Errorf("Assign() Conf = %.3f, want %.3f", q.Stats.Conf, tt.conf)
+			}
+		})
+	}
+}
+
+func TestAssignExcluded(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	filter, err := taxDB.NewFilter("Homo sapiens", "")
+	if err != nil {
+		t.Fatalf("NewFilter() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		taxids   []int
+		want     int
+		status   string
+		excluded int
+	}{
+		{name: "some hits excluded", taxids: []int{300, 100, 300}, want: 100, status: "assigned", excluded: 2},
+		{name: "all hits excluded", taxids: []int{300, 300}, want: -1, status: "excluded", excluded: 2},
+		{name: "excluded and not in the taxonomy", taxids: []int{300, 999, 300}, want: -1, status: "excluded", excluded: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &QueryRes{Query: Header("q1"), Hits: taxidHits(tt.taxids...), Parsed: len(tt.taxids)}
+			q.Assign(taxDB, filter) // Fails if no hit is left
+			if q.Taxid != tt.want || q.Status.String() != tt.status {
+				t.Errorf("Assign() = %d (%s), want %d (%s)", q.Taxid, q.Status, tt.want, tt.status)
+			}
+			if q.Stats.Excluded != tt.excluded {
+				t.Errorf("Assign() excluded %d hits, want %d", q.Stats.Excluded, tt.excluded)
+			}
+		})
+	}
+}
+
+func TestAssignUnmapped(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name     string
+		subjects []string
+		taxids   []int // Given by the subject taxid column, 0 if missing
+		want     int
+		status   string
+		unmapped int
+	}{
+		{name: "subjects without GI or taxid", subjects: []string{"no GI", "sp|P12345|"}, want: -1, status: "unmapped_subjects", unmapped: 2},
+		{name: "GIs without a dictionary", subjects: []string{"gi|1|", "gi|2|"}, want: -1, status: "unmapped_subjects", unmapped: 2},
+		{name: "some subjects unmapped", subjects: []string{"no GI", "gi|1|", "s2"}, taxids: []int{0, 0, 100}, want: 100, status: "assigned", unmapped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logbuf := captureLog(t)
+			var input strings.Builder
+			for i, subject := range tt.subjects {
+				line := m8("q1", subject, fmt.Sprint(100-i))
+				if i < len(tt.taxids) && tt.taxids[i] != 0 {
+					line = strings.TrimSuffix(line, "\n") + fmt.Sprintf("\t%d\n", tt.taxids[i])
+				}
+				input.WriteString(line)
+			}
+			out := make(chan *BlastBlock, 1)
+			if _, err := Procfile(bufio.NewReader(strings.NewReader(input.String())), out, true, nil); err != nil {
+				t.Fatalf("Procfile() error = %v", err)
+			}
+			q := ParseRecordFilters(*<-out)
+			if len(q.Errors) != 0 || len(q.Hits) != len(tt.subjects) {
+				t.Fatalf("ParseRecordFilters() = %d hits, errors %v, want %d hits", len(q.Hits), q.Errors, len(tt.subjects))
+			}
+			q.Assign(taxDB, nil) // Fails if no hit is left
+			if q.Taxid != tt.want || q.Status.String() != tt.status {
+				t.Errorf("Assign() = %d (%s), want %d (%s)", q.Taxid, q.Status, tt.want, tt.status)
+			}
+			if q.Stats.Unmapped != tt.unmapped {
+				t.Errorf("Assign() unmapped %d hits, want %d", q.Stats.Unmapped, tt.unmapped)
+			}
+			if logbuf.Len() != 0 {
+				t.Errorf("Assign() logged %q, want no warnings", logbuf)
+			}
+		})
+	}
+}
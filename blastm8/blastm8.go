@@ -9,8 +9,6 @@ import (
 	"bytes"
 	"strconv"
 	"regexp" //HINT: To extract GIs -- TODO: Profile the 2 alternatives given below
-	"log"
-	"errors"
 //	"os"
 )
 
@@ -23,7 +21,13 @@ type Header []byte
 //Hit gives single Blast hit information
 type Hit struct { // Was Blast
 	gi               int // We may operate in GI space
+	subject          Header
+	taxid            int // From the staxids column if present, otherwise filled by QueryRes.Assign (0 if the GI can't be mapped, -1 without GI)
 	bitsc            float64
+	evalue           float64
+	ident            float64
+	qstart, qend     int // Query coordinates of the alignment (qstart > qend in reverse frames)
+	alnlen           int
 }
 
 //Hits represent a  collection of hits
@@ -33,12 +37,20 @@ type Hits []*Hit
 type BlastBlock struct {
 	header Header
 	block  []byte
+	mate   *BlastBlock // Block of the second mate of a fragment (see PairMates)
+	line   int         // Line number of the first line of the block
+	holes  []int       // Line numbers of the malformed lines skipped inside the block
 }
 
 //QueryRes has the needed information about the hits of a query
 type QueryRes struct {
 	Query  Header
 	Hits   Hits
+	Taxid  int           // Taxid of the LCA (filled by Assign, -1 if the query can't be assigned)
+	Status Status        // Why the query can't be assigned (filled by Assign)
+	Stats  Stats         // Supporting evidence of the assignment (filled by Assign)
+	Errors []*ParseError // Malformed lines of the query (not included in Hits)
+	Parsed int           // Hits read from the input, before the filters
 }
 
 //findIndex returns the index in the Hits slice with the last significant Hit.
@@ -63,6 +75,11 @@ func (h Hit) String() string {
 	return fmt.Sprintf("GI:%d\t%.2f", h.gi, h.bitsc)
 }
 
+//Subject returns the subject field of the corresponding Hit
+func (h *Hit) Subject() Header {
+	return h.subject
+}
+
 //GI returns the GI of the corresponding Hit
 func (h *Hit) GI() int {
 	return h.gi
@@ -73,6 +90,36 @@ func (h *Hit) Bitsc() float64 {
 	return h.bitsc
 }
 
+//Ident returns the percentage of identity of the corresponding Hit
+func (h *Hit) Ident() float64 {
+	return h.ident
+}
+
+//AlnLen returns the alignment length of the corresponding Hit
+func (h *Hit) AlnLen() int {
+	return h.alnlen
+}
+
+//QStart returns the start of the alignment in the query
+func (h *Hit) QStart() int {
+	return h.qstart
+}
+
+//QEnd returns the end of the alignment in the query
+func (h *Hit) QEnd() int {
+	return h.qend
+}
+
+//EValue returns the e-value of the corresponding Hit
+func (h *Hit) EValue() float64 {
+	return h.evalue
+}
+
+//Taxid returns the taxid of the corresponding Hit (0 if not mapped yet or unmappable, -1 if the subject has neither GI nor taxid)
+func (h *Hit) Taxid() int {
+	return h.taxid
+}
+
 //String Stringifies a query result
 func (t QueryRes) String() string {
 	s := fmt.Sprintf("%s\n", t.Query)
@@ -119,69 +166,125 @@ func (h Hits) Swap(i, j int) {
 // 	return
 // }
 
-func (b Header) extractGI () (int, error) {
-	gib := make([]byte, 0, 10)
-	for i,v := range b {
-		if v == 'g' && b[i+1] == 'i' && b[i+2] == '|' {
-			for j:=i+3; j<len(b); j++ {
-				if b[j] == '|' {
-					gi, err := strconv.Atoi(string(gib))
-//					gi := atoi(gib)
- 					if err != nil {
- 						return -1, err
- 					}
-					return gi, nil
-				}
-				gib = append(gib, b[j])
-			}
-			nerr := errors.New(fmt.Sprintf("No | found after GI in %s", b))
-			return -1, nerr
-		}
+//extractGI returns the number between "gi|" and the next "|" in the header.
+//Returns false if the header has no valid GI
+func (b Header) extractGI () (int, bool) {
+	pos := bytes.Index(b, []byte("gi|"))
+	if pos < 0 {
+		return -1, false
+	}
+	gib := b[pos+3:]
+	end := bytes.IndexByte(gib, '|')
+	if end < 0 {
+		return -1, false
+	}
+	gi, err := atoi(gib[:end])
+	if err != nil || gi < 0 {
+		return -1, false
+	}
+	return gi, true
+}
+
+//send passes b to out unless quit is closed first. Returns false if quit is closed
+func send(out chan<- *BlastBlock, b *BlastBlock, quit <-chan struct{}) bool {
+	select {
+	case out <- b:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+//aborted tells if quit is closed
+func aborted(quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
 	}
-	lerr := errors.New(fmt.Sprintf("No gi| found in: %s", b))
-	return -1, lerr
 }
 
 //ProcFile reads the query results from a blast m8-formatted file and passes the results
 //to the queryChan channel. What is passed is the raw block of lines corresponding to a single query in the blast file.
-func Procfile(iblast *bufio.Reader, queryChan chan<- *BlastBlock) {
-	bufLen := 10000
-	subjCollect := bytes.NewBuffer(make([]byte, 0, bufLen))
-	var query []byte
+//The lines are read without copies from iblast's buffer into the block, the header of the block is a slice of it.
+//Lines whose query can't be extracted are skipped and counted, unless strict is set, in which case the
+//reading stops with a *ParseError. Comment lines (-outfmt 7) are skipped, and the queries they report without hits
+//are passed as blocks without lines. The reading stops without error when quit is closed (it may be nil).
+//queryChan is closed at the end. Returns the number of skipped lines and any error it may encounter in the process
+func Procfile(iblast *bufio.Reader, queryChan chan<- *BlastBlock, strict bool, quit <-chan struct{}) (int, error) {
+	defer close(queryChan)
+	blockCap := 4096
+	block := make([]byte, 0, blockCap)
+	qlen := -1 // Length of the query of the current block
+	var holes []int
+	first, lineno, skipped := 0, 0, 0
+	var scratch []byte
+	var tracker noHitsTracker
 	for ;; {
-		line, _, ierr := iblast.ReadLine() // TODO: Check isIndex
+		line, ierr := readLine(iblast, &scratch)
 		if ierr == io.EOF {
-			block := subjCollect.Bytes()
-			queryChan <- &BlastBlock{ header : Header(query), block : block[:len(block)-1] }
-			close(queryChan)
-			return
+			if qlen >= 0 {
+				send(queryChan, &BlastBlock{ header : Header(block[:qlen]), block : block, line : first, holes : holes }, quit)
+			}
+			return skipped, nil
+		}
+		if ierr != nil {
+			return skipped, ierr
+		}
+		lineno++
+		if aborted(quit) {
+			return skipped, nil
 		}
 
+		if isComment(line) {
+			if noHits := tracker.comment(line); noHits != nil {
+				if qlen >= 0 {
+					if !send(queryChan, &BlastBlock{ header: Header(block[:qlen]), block : block, line : first, holes : holes }, quit) {
+						return skipped, nil
+					}
+					block = make([]byte, 0, blockCap)
+					qlen = -1
+					holes = nil
+				}
+				if !send(queryChan, &BlastBlock{ header: Header(noHits), line : lineno }, quit) {
+					return skipped, nil
+				}
+			} else if qlen >= 0 {
+				holes = append(holes, lineno)
+			}
+			continue
+		}
 		currQuery, qerr := extractQuery(line)
 		if qerr != nil {
-			log.Printf("WARNING: I can't extract the query field from this line: %s\n%s\n", line, qerr)
+			perr := &ParseError{Line: lineno, Text: string(line), Err: qerr}
+			if strict {
+				return skipped, perr
+			}
+			warn(perr)
+			skipped++
+			if qlen >= 0 {
+				holes = append(holes, lineno)
+			}
 			continue // offending line is not passed
 		}
-		if (query == nil) {
-			query = currQuery
-		}
-		// Still same query
-		if (bytes.Equal(currQuery, query)) {
-			_, err := subjCollect.Write(append(line, '\n'))
-			if err != nil {
-				log.Println("WARNING: Error collecting line from blast:\nLINE:\n%s\nERROR: %s\n", line, err)
+		if qlen >= 0 && !bytes.Equal(currQuery, block[:qlen]) { // New Query
+			if !send(queryChan, &BlastBlock{ header: Header(block[:qlen]), block : block, line : first, holes : holes }, quit) {
+				return skipped, nil
+			}
+			if len(block) > blockCap {
+				blockCap = len(block)
 			}
-		} else { // New Query
-			block := subjCollect.Bytes()
-			passQuery := make([]byte, len(query))
-			copy (passQuery, query)
-
-			queryChan <- &BlastBlock{ header: Header(passQuery), block : block[:len(block)-1] }
-			subjCollect = bytes.NewBuffer(make([]byte, 0, bufLen))
-			subjCollect.Write(append(line, '\n'))
-			query = make([]byte, len(currQuery))
-			copy(query, currQuery)
+			block = make([]byte, 0, blockCap)
+			qlen = -1
+			holes = nil
 		}
+		if qlen < 0 {
+			qlen = len(currQuery)
+			first = lineno
+		}
+		block = append(block, line...)
+		block = append(block, '\n')
 	}
 }
 
@@ -189,10 +292,10 @@ func Procfile(iblast *bufio.Reader, queryChan chan<- *BlastBlock) {
 func extractQuery (line []byte) ([]byte, error) {
 	pos := bytes.IndexByte(line, '\t')
 	if pos < 0 {
-		return nil, errors.New("Line is not tab separated. This line can't be processed")
+		return nil, ErrNotTabSeparated
 	}
 	if pos == 0 {
-		return nil, errors.New("Line with a blank query field (starts with a <tab> character")
+		return nil, ErrBlankQuery
 	}
 
 	return line[0:pos], nil
@@ -201,91 +304,130 @@ func extractQuery (line []byte) ([]byte, error) {
 // ParseRecord parses the lines for a query (blast m8-formatted) and write the information in a QueryRes
 // Only the lines with bit score greater than the best score * scLim are processed
 func ParseRecord (bb BlastBlock, scLim float64) *QueryRes {
+	return ParseRecordFilters(bb, BitscoreFactor(scLim))
+}
+
+//parseEvalue parses an e-value, including the legacy blastall ones without mantissa ("e-180")
+func parseEvalue(b []byte) (float64, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && (b[0] == 'e' || b[0] == 'E') {
+		return strconv.ParseFloat("1"+string(b), 64)
+	}
+	return atof(b)
+}
+
+//parseTaxid parses the first taxid of a staxids column (';' separated taxids, "N/A" if unknown).
+//Returns 0 if there is no valid taxid
+func parseTaxid(b []byte) int {
+	if sc := bytes.IndexByte(b, ';'); sc >= 0 {
+		b = b[:sc]
+	}
+	taxid, err := atoi(b)
+	if err != nil || taxid < 0 {
+		return 0
+	}
+	return taxid
+}
+
+// ParseRecordFilters parses the lines for a query (blast m8-formatted) and write the information in a QueryRes
+// The hits are passed through the filters in the given order and returned sorted by bit score.
+// The hits are allocated together and their subjects are slices of the block.
+// Malformed lines are skipped, reported in the Errors of the QueryRes and logged (unless StrictConvert is set)
+func ParseRecordFilters (bb BlastBlock, filters ...HitFilter) *QueryRes {
 	qRes := &QueryRes{}
 	qRes.Query = bb.header
-	bestBs := float64(0)
-	recs := bytes.Split(bb.block, []byte{'\n'})
-	for _, blastLine := range recs {
-		nextHit, err := parseblast(blastLine)
-		if err != nil {
-			log.Printf("WARNING: Ignoring this blast line: %s\n%s\n", blastLine, err)
+	slab := make([]Hit, bytes.Count(bb.block, []byte{'\n'})+1)
+	qRes.Hits = make(Hits, 0, len(slab))
+	fields := make([][]byte, 0, 12)
+	lineno, holes := bb.line, bb.holes
+	for rest := bb.block; len(rest) > 0; lineno++ {
+		for len(holes) > 0 && holes[0] == lineno { // Skip the numbers of the lines removed by Procfile
+			lineno++
+			holes = holes[1:]
+		}
+		blastLine := rest
+		if nl := bytes.IndexByte(rest, '\n'); nl >= 0 {
+			blastLine, rest = rest[:nl], rest[nl+1:]
+		} else {
+			rest = nil
+		}
+		if len(blastLine) == 0 {
 			continue
 		}
-		qRes.Hits = append(qRes.Hits, nextHit)
-		if bestBs < nextHit.bitsc {
-			bestBs = nextHit.bitsc
+		nextHit := &slab[len(qRes.Hits)]
+		var perr *ParseError
+		fields, perr = parseblast(blastLine, fields, nextHit)
+		if perr != nil {
+			if bb.line > 0 {
+				perr.Line = lineno
+			}
+			perr.Text = string(blastLine)
+			qRes.Errors = append(qRes.Errors, perr)
+			if !StrictConvert {
+				warn(perr)
+			}
+			continue
 		}
+		qRes.Hits = append(qRes.Hits, nextHit)
 	}
-	bsLim := bestBs * scLim
+	qRes.Parsed = len(qRes.Hits)
 	sort.Sort(qRes.Hits)
-	index := qRes.Hits.findIndex(bsLim)
-	qRes.Hits = qRes.Hits[:index]
+	for _, filter := range filters {
+		qRes.Hits = filter(qRes.Hits)
+	}
 	return qRes
 }
 
-
-func parseblast(line []byte) (*Hit, error) {
-	var newB *Hit
-
-	parts := bytes.Split(line, []byte("\t"))
-//	parts := getFields(line)
-	bitscStr := bytes.TrimSpace(parts[11])
-	// if parts[11][0] == 32 {
-	// 	bitscStr = parts[11][1:]
-	// } else {
-	// 	bitscStr = parts[11]
-	// }
-
-	bitsc, bse := strconv.ParseFloat(string(bitscStr), 64)
+//parseblast parses a line of m8-formatted blast in hit. The fields slice is reused to split the line and returned.
+//An optional 13th column with the subject taxids (staxids) makes the GI optional,
+//the hits without both get taxid -1.
+//The returned error has no line information
+func parseblast(line []byte, fields [][]byte, hit *Hit) ([][]byte, *ParseError) {
+	parts := splitFields(line, fields)
+	if len(parts) < 12 {
+		return parts, &ParseError{Err: ErrFieldCount}
+	}
+	bitsc, bse := atof(parts[11])
 	if bse != nil {
-		return nil, errors.New(fmt.Sprintf("Error parsing bit score %s as integer: %s\n", parts[11], bse))
+		return parts, &ParseError{Field: "bit score", Err: ErrBadNumber}
 	}
-	gi, gierr := Header(parts[1]).extractGI()
-	if gierr != nil {
-		return nil, errors.New(fmt.Sprintf("Error extracting GI from %s: %s", parts[1], gierr))
+	ident, ide := atof(parts[2])
+	if ide != nil {
+		return parts, &ParseError{Field: "identity", Err: ErrBadNumber}
 	}
-	newB = &Hit{
-	gi: gi,
-	bitsc:   bitsc}
-	return newB, nil
-}
-
-func getFields (line []byte) [][]byte {
-	fields := make ([][]byte, 0, 12)
-	this := make([]byte, 0, 20)
-	for _, ch := range line {
-		if ch == 9 { // \t
-			fields = append(fields, this)
-			this = make([]byte, 0, 20)
-			continue
-		}
-		if ch == 10 { // \n
-			fields = append(fields, this)
-			return fields
-		}
-		this = append(this, ch)
+	alnlen, ale := atoi(parts[3])
+	if ale != nil {
+		return parts, &ParseError{Field: "alignment length", Err: ErrBadNumber}
 	}
-	fields = append(fields, this)
-	return (fields)
-}
-
-//To profile a custom implementation of atoi (-- atoi is one of the most expensive operations in the program
-//This may have now less impact, since the conversions have been moved to parallelized parts of the program
-func atoi (dbytes []byte) int {
-	i := 0
-	is_negative := false
-
-	for _, ch := range dbytes {
-		if ch < 15 && ch > 26 && ch != 13 { // !is_digit(ch) && ch != '-'
-			continue
-		}
-		if ch == 13 { // -
-			is_negative = true
-		}
-		i = (i*10) + (int(ch) - 16)%10
+	qstart, qse := atoi(parts[6])
+	if qse != nil {
+		return parts, &ParseError{Field: "query start", Err: ErrBadNumber}
 	}
-	if is_negative {
-		return i
+	qend, qee := atoi(parts[7])
+	if qee != nil {
+		return parts, &ParseError{Field: "query end", Err: ErrBadNumber}
 	}
-	return -i
+	evalue, eve := parseEvalue(parts[10])
+	if eve != nil {
+		return parts, &ParseError{Field: "e-value", Err: ErrBadNumber}
+	}
+	taxid := 0
+	if len(parts) > 12 {
+		taxid = parseTaxid(parts[12])
+	}
+	gi, hasGI := Header(parts[1]).extractGI()
+	if !hasGI && taxid == 0 { // The subject can't be mapped, but the hit still counts (see QueryRes.Assign)
+		taxid = -1
+	}
+	*hit = Hit{
+	gi: gi,
+	subject: Header(parts[1]),
+	taxid:   taxid,
+	bitsc:   bitsc,
+	evalue:  evalue,
+	ident:   ident,
+	qstart:  qstart,
+	qend:    qend,
+	alnlen:  alnlen}
+	return parts, nil
 }
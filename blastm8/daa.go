@@ -0,0 +1,298 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+//DIAMOND DAA files have a fixed header followed by the alignments block (one record per query) and
+//the blocks with the names and lengths of the subjects referenced by the alignments
+const (
+	daaMagic      = 0x3c0e53476d3ee36b
+	daaHeaderSize = 16 + 6*8 + 8*4 + 4*8 + 256 + 256*8 // Header 1 and 2
+
+	daaBlockAlignments = 1
+	daaBlockRefNames   = 2
+
+	daaModeBlastp = 2
+	daaModeBlastx = 3
+	daaModeBlastn = 4
+)
+
+//Edit operations of the DAA alignment transcripts (2 highest bits of each byte)
+const (
+	daaOpMatch = iota
+	daaOpInsertion
+	daaOpDeletion
+	daaOpSubstitution
+)
+
+//daaHeader has the fields of the DAA header needed to read the alignments
+type daaHeader struct {
+	dbLetters  uint64
+	mode       int32
+	k, lambda  float64
+	blockTypes [256]uint8
+	blockSizes [256]uint64
+}
+
+//isDAA tells if the file starts like a DAA file
+func isDAA(head []byte) bool {
+	return len(head) >= 8 && binary.LittleEndian.Uint64(head) == daaMagic
+}
+
+func readDAAHeader(r io.Reader) (*daaHeader, error) {
+	var raw struct {
+		Magic, Version                                              uint64
+		Build, DbSeqs, DbSeqsUsed, DbLetters, Flags, QueryRecords   uint64
+		Mode, GapOpen, GapExtend, Reward, Penalty, Res1, Res2, Res3 int32
+		K, Lambda, Evalue, Res5                                     float64
+		BlockTypes                                                  [256]uint8
+		BlockSizes                                                  [256]uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+		return nil, fmt.Errorf("Truncated DAA header: %s", err)
+	}
+	if raw.Magic != daaMagic {
+		return nil, errors.New("Not a DAA file")
+	}
+	if raw.Mode != daaModeBlastp && raw.Mode != daaModeBlastx && raw.Mode != daaModeBlastn {
+		return nil, fmt.Errorf("Unsupported DAA alignment mode %d", raw.Mode)
+	}
+	return &daaHeader{
+		dbLetters:  raw.DbLetters,
+		mode:       raw.Mode,
+		k:          raw.K,
+		lambda:     raw.Lambda,
+		blockTypes: raw.BlockTypes,
+		blockSizes: raw.BlockSizes,
+	}, nil
+}
+
+//refNames reads the names (first word) of the subjects from their block after the alignments
+func (h *daaHeader) refNames(src io.ReaderAt) ([][]byte, error) {
+	var names [][]byte
+	off := int64(daaHeaderSize)
+	for i, bt := range h.blockTypes {
+		size := int64(h.blockSizes[i])
+		if bt == daaBlockRefNames {
+			buf := bufio.NewReaderSize(io.NewSectionReader(src, off, size), 1024*1024)
+			for {
+				name, err := buf.ReadBytes(0)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				names = append(names, firstWord(name[:len(name)-1]))
+			}
+		}
+		off += size
+	}
+	if names == nil {
+		return nil, errors.New("No subject names in the DAA file")
+	}
+	return names, nil
+}
+
+//daaRecord walks the bytes of a query record
+type daaRecord struct {
+	b   []byte
+	err error
+}
+
+func (r *daaRecord) take(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errors.New("Truncated DAA query record")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *daaRecord) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *daaRecord) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+//packed reads an integer stored in 1, 2 or 4 bytes (kind 0, 1 or 2)
+func (r *daaRecord) packed(kind uint8) uint32 {
+	switch kind {
+	case 0:
+		return uint32(r.u8())
+	case 1:
+		if b := r.take(2); b != nil {
+			return uint32(binary.LittleEndian.Uint16(b))
+		}
+		return 0
+	}
+	return r.u32()
+}
+
+func (r *daaRecord) cstring() []byte {
+	if r.err != nil {
+		return nil
+	}
+	end := bytes.IndexByte(r.b, 0)
+	if end < 0 {
+		r.err = errors.New("Truncated DAA query record")
+		return nil
+	}
+	s := r.b[:end]
+	r.b = r.b[end+1:]
+	return s
+}
+
+//daaMatch is an alignment of a DAA query record
+type daaMatch struct {
+	subject                      uint32
+	score                        uint32
+	qbegin, sbegin               int
+	reverse                      bool
+	qspan, sspan                 int // Query (in alignment letters) and subject lengths of the alignment
+	length, identities, mismatch int
+	gapopen                      int
+}
+
+//readMatch reads the next alignment of the record and parses its edit transcript
+func (r *daaRecord) readMatch(m *daaMatch) {
+	*m = daaMatch{subject: r.u32()}
+	flag := r.u8()
+	m.score = r.packed(flag & 3)
+	m.qbegin = int(r.packed((flag >> 2) & 3))
+	m.sbegin = int(r.packed((flag >> 4) & 3))
+	m.reverse = flag&(1<<6) != 0
+	inDeletion := false
+	for r.err == nil {
+		op := r.u8()
+		if op == 0 { // Terminator
+			break
+		}
+		count := int(op & 63)
+		switch op >> 6 {
+		case daaOpMatch:
+			m.identities += count
+			m.qspan += count
+			m.sspan += count
+		case daaOpSubstitution:
+			count = 1
+			m.mismatch++
+			m.qspan++
+			m.sspan++
+		case daaOpInsertion: // Gap in the subject
+			m.gapopen++
+			m.qspan += count
+		case daaOpDeletion: // Gap in the query, one letter per operation
+			count = 1
+			if !inDeletion {
+				m.gapopen++
+			}
+			m.sspan++
+		}
+		inDeletion = op>>6 == daaOpDeletion
+		m.length += count
+	}
+}
+
+//queryCoords returns the 1-based query coordinates of the alignment (qstart > qend in the reverse strand)
+func (h *daaHeader) queryCoords(m *daaMatch) (int, int) {
+	span := m.qspan
+	if h.mode == daaModeBlastx {
+		span *= 3
+	}
+	if m.reverse && h.mode != daaModeBlastp {
+		return m.qbegin + 1, m.qbegin - span + 2
+	}
+	return m.qbegin + 1, m.qbegin + span
+}
+
+//ConvertDAA converts a DIAMOND DAA file to m8 lines. The names of the subjects are stored after the alignments,
+//so the file must be seekable (uncompressed and not piped). The alignments are read as a stream
+func ConvertDAA(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	if src == nil {
+		return errors.New("DAA files can't be read compressed or from a pipe")
+	}
+	h, err := readDAAHeader(r)
+	if err != nil {
+		return err
+	}
+	names, err := h.refNames(src)
+	if err != nil {
+		return err
+	}
+	ln2, lnK := math.Log(2), math.Log(h.k)
+	var size [4]byte
+	var buf, line []byte
+	var m daaMatch
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return fmt.Errorf("Truncated DAA alignments block: %s", err)
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n == 0 { // End of the alignments
+			return nil
+		}
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("Truncated DAA alignments block: %s", err)
+		}
+		rec := &daaRecord{b: buf}
+		qlen := int(rec.u32())
+		query := firstWord(rec.cstring())
+		flags := rec.u8()
+		bits := 5 // Packed query sequence, that is skipped
+		if h.mode != daaModeBlastp {
+			bits = 2
+			if flags&1 != 0 {
+				bits = 3
+			}
+		}
+		rec.take((qlen*bits + 7) / 8)
+		if h.mode == daaModeBlastx {
+			qlen /= 3
+		}
+		for rec.err == nil && len(rec.b) > 0 {
+			rec.readMatch(&m)
+			if rec.err != nil {
+				break
+			}
+			if int(m.subject) >= len(names) {
+				return fmt.Errorf("Subject %d of query %s not found in the DAA file", m.subject, query)
+			}
+			bitsc := (h.lambda*float64(m.score) - lnK) / ln2
+			evalue := float64(h.dbLetters) * float64(qlen) * math.Pow(2, -bitsc)
+			ident := 0.0
+			if m.length > 0 {
+				ident = 100 * float64(m.identities) / float64(m.length)
+			}
+			qstart, qend := h.queryCoords(&m)
+			line = M8Line(line[:0], query, names[m.subject], ident, m.length, m.mismatch, m.gapopen,
+				qstart, qend, m.sbegin+1, m.sbegin+m.sspan, evalue, math.Round(bitsc*10)/10, 0)
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		if rec.err != nil {
+			return fmt.Errorf("%s (query %s)", rec.err, query)
+		}
+	}
+}
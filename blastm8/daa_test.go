@@ -0,0 +1,176 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+//daaAlignment is an alignment of the DAA test files: the packed fields are stored in 4 bytes
+type daaAlignment struct {
+	subject, score, qbegin, sbegin uint32
+	reverse                        bool
+	transcript                     []byte
+}
+
+//daaQuery is a query record of the DAA test files
+type daaQuery struct {
+	name       string
+	qlen       uint32
+	alignments []daaAlignment
+	truncated  bool // Without the terminator of the last transcript
+}
+
+//daaFile builds a DAA file with the given mode, queries and subject names
+func daaFile(mode int32, queries []daaQuery, subjects []string) []byte {
+	var alns bytes.Buffer
+	for _, q := range queries {
+		var rec bytes.Buffer
+		binary.Write(&rec, binary.LittleEndian, q.qlen)
+		rec.WriteString(q.name + " description")
+		rec.WriteByte(0)
+		rec.WriteByte(0) // Flags
+		bits := 5
+		if mode != daaModeBlastp {
+			bits = 2
+		}
+		rec.Write(make([]byte, (int(q.qlen)*bits+7)/8))
+		for _, a := range q.alignments {
+			binary.Write(&rec, binary.LittleEndian, a.subject)
+			flag := uint8(2 | 2<<2 | 2<<4)
+			if a.reverse {
+				flag |= 1 << 6
+			}
+			rec.WriteByte(flag)
+			binary.Write(&rec, binary.LittleEndian, []uint32{a.score, a.qbegin, a.sbegin})
+			rec.Write(a.transcript)
+			rec.WriteByte(0)
+		}
+		if q.truncated {
+			rec.Truncate(rec.Len() - 1)
+		}
+		binary.Write(&alns, binary.LittleEndian, uint32(rec.Len()))
+		alns.Write(rec.Bytes())
+	}
+	binary.Write(&alns, binary.LittleEndian, uint32(0))
+	var names bytes.Buffer
+	for _, s := range subjects {
+		names.WriteString(s)
+		names.WriteByte(0)
+	}
+	var raw struct {
+		Magic, Version                                              uint64
+		Build, DbSeqs, DbSeqsUsed, DbLetters, Flags, QueryRecords   uint64
+		Mode, GapOpen, GapExtend, Reward, Penalty, Res1, Res2, Res3 int32
+		K, Lambda, Evalue, Res5                                     float64
+		BlockTypes                                                  [256]uint8
+		BlockSizes                                                  [256]uint64
+	}
+	raw.Magic, raw.Version = daaMagic, 1
+	raw.DbLetters = 1000000
+	raw.Mode = mode
+	raw.K, raw.Lambda = 0.041, 0.267
+	raw.BlockTypes[0], raw.BlockSizes[0] = daaBlockAlignments, uint64(alns.Len())
+	raw.BlockTypes[1], raw.BlockSizes[1] = daaBlockRefNames, uint64(names.Len())
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, &raw)
+	file.Write(alns.Bytes())
+	file.Write(names.Bytes())
+	return file.Bytes()
+}
+
+//transcript: 10 matches, a substitution, a 2 letters gap in the subject, a 2 letters gap in the query and 5 matches
+var daaTranscript = []byte{daaOpMatch<<6 | 10, daaOpSubstitution<<6 | 1, daaOpInsertion<<6 | 2,
+	daaOpDeletion<<6 | 3, daaOpDeletion<<6 | 4, daaOpMatch<<6 | 5}
+
+func TestConvertDAA(t *testing.T) {
+	subjects := []string{"gi|11|ref|X1| first subject", "gi|22|ref|X2|"}
+	tests := []struct {
+		name    string
+		mode    int32
+		queries []daaQuery
+		want    string
+	}{
+		{
+			name: "blastp",
+			mode: daaModeBlastp,
+			queries: []daaQuery{
+				{name: "q1", qlen: 50, alignments: []daaAlignment{
+					{subject: 1, score: 100, qbegin: 4, sbegin: 9, transcript: daaTranscript},
+					{subject: 0, score: 50, qbegin: 0, sbegin: 0, transcript: []byte{daaOpMatch<<6 | 20}},
+				}},
+				{name: "q2", qlen: 30},
+			},
+			want: "q1\tgi|22|ref|X2|\t75.00\t20\t1\t2\t5\t22\t10\t27\t5.2e-06\t43.1\n" +
+				"q1\tgi|11|ref|X1|\t100.00\t20\t0\t0\t1\t20\t1\t20\t3.27\t23.9\n",
+		},
+		{
+			name: "blastx reverse strand",
+			mode: daaModeBlastx,
+			queries: []daaQuery{
+				{name: "read1", qlen: 300, alignments: []daaAlignment{
+					{subject: 0, score: 100, qbegin: 200, sbegin: 0, reverse: true, transcript: []byte{daaOpMatch<<6 | 30}},
+				}},
+			},
+			want: "read1\tgi|11|ref|X1|\t100.00\t30\t0\t0\t201\t112\t1\t30\t1.04e-05\t43.1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := daaFile(tt.mode, tt.queries, subjects)
+			var out bytes.Buffer
+			w := bufio.NewWriter(&out)
+			if err := ConvertDAA(bufio.NewReader(bytes.NewReader(file)), bytes.NewReader(file), w); err != nil {
+				t.Fatalf("ConvertDAA() error = %v", err)
+			}
+			w.Flush()
+			if got := out.String(); got != tt.want {
+				t.Errorf("ConvertDAA() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertDAAErrors(t *testing.T) {
+	good := daaFile(daaModeBlastp, []daaQuery{{name: "q1", qlen: 50, alignments: []daaAlignment{
+		{subject: 0, score: 100, transcript: daaTranscript}}}}, []string{"s1"})
+	unknown := daaFile(daaModeBlastp, []daaQuery{{name: "q1", qlen: 50, alignments: []daaAlignment{
+		{subject: 5, score: 100, transcript: daaTranscript}}}}, []string{"s1"})
+	truncated := daaFile(daaModeBlastp, []daaQuery{{name: "q1", qlen: 50, truncated: true, alignments: []daaAlignment{
+		{subject: 0, score: 100, transcript: daaTranscript}}}}, []string{"s1"})
+	tests := []struct {
+		name      string
+		file, src []byte // The stream and the seekable file (nil if not seekable)
+		want      string
+	}{
+		{"not seekable", good, nil, "can't be read compressed"},
+		{"not a DAA file", append([]byte{1}, good[1:]...), good, "Not a DAA file"},
+		{"truncated header", good[:100], good, "Truncated DAA header"},
+		{"unknown subject", unknown, unknown, "Subject 5 of query q1"},
+		{"truncated alignments", good[:daaHeaderSize+20], good, "Truncated DAA alignments block"},
+		{"truncated record", truncated, truncated, "Truncated DAA query record (query q1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := bufio.NewWriter(&bytes.Buffer{})
+			var err error
+			if tt.src == nil {
+				err = ConvertDAA(bufio.NewReader(bytes.NewReader(tt.file)), nil, w)
+			} else {
+				err = ConvertDAA(bufio.NewReader(bytes.NewReader(tt.file)), bytes.NewReader(tt.src), w)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ConvertDAA() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectDAA(t *testing.T) {
+	file := daaFile(daaModeBlastp, nil, []string{"s1"})
+	if f := DetectFormat(bufio.NewReader(bytes.NewReader(file))); f.Name != "daa" {
+		t.Errorf("DetectFormat() = %q, want \"daa\"", f.Name)
+	}
+}
@@ -0,0 +1,82 @@
+package blastm8
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+//Kinds of malformed lines (see ParseError)
+var (
+	ErrNotTabSeparated = errors.New("line is not tab separated")
+	ErrBlankQuery      = errors.New("blank query field")
+	ErrFieldCount      = errors.New("less than 12 tab separated fields")
+	ErrBadNumber       = errors.New("invalid number")
+	ErrBadRecord       = errors.New("malformed record")
+)
+
+//MaxWarnings is the number of malformed lines that are logged, the rest are only counted
+var MaxWarnings int64 = 10
+
+//StrictConvert makes the converters of the other formats stop on the first malformed record,
+//otherwise they skip it (see ConvertSkipped). ParseRecordFilters doesn't log the malformed lines it reports
+//when it is set, as the caller stops at the first one
+var StrictConvert = false
+
+var warnings, convertSkipped int64
+
+//ParseError is a malformed line in a blast file
+type ParseError struct {
+	Line   int    // Line number in the input (1-based, 0 if unknown)
+	Record int    // Record number in binary inputs (1-based), instead of Line
+	Field  string // Offending field (empty if the whole line is malformed)
+	Text   string // Offending line (or name of the offending record)
+	Err    error  // One of the Err* kinds, or a more detailed error of a binary record
+}
+
+func (e *ParseError) Error() string {
+	where := "line"
+	if e.Line > 0 {
+		where = fmt.Sprintf("line %d", e.Line)
+	}
+	if e.Record > 0 {
+		where = fmt.Sprintf("record %d", e.Record)
+	}
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s: %s", where, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", where, e.Err)
+}
+
+//Unwrap returns the kind of the error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//warn logs a malformed line, up to MaxWarnings times
+func warn(perr *ParseError) {
+	n := atomic.AddInt64(&warnings, 1)
+	if n <= MaxWarnings {
+		log.Printf("WARNING: Ignoring malformed blast line (%s): %s\n", perr, perr.Text)
+	}
+	if n == MaxWarnings+1 {
+		log.Printf("WARNING: Too many malformed blast lines, only counting them from now on\n")
+	}
+}
+
+//skipRecord returns perr if StrictConvert is set, otherwise the malformed record is logged (see warn),
+//counted and skipped
+func skipRecord(perr *ParseError) error {
+	if StrictConvert {
+		return perr
+	}
+	warn(perr)
+	atomic.AddInt64(&convertSkipped, 1)
+	return nil
+}
+
+//ConvertSkipped returns the number of malformed records skipped by the converters
+func ConvertSkipped() int64 {
+	return atomic.LoadInt64(&convertSkipped)
+}
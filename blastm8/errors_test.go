@@ -0,0 +1,118 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//captureLog sends the log output to a buffer and resets the warnings count until the end of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	oldWarnings := warnings
+	warnings = 0
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		warnings = oldWarnings
+	})
+	return &buf
+}
+
+func TestParseErrorLines(t *testing.T) {
+	captureLog(t)
+	input := "# BLASTN 2.12.0+\n" +
+		m8("q1", "gi|1|", "50") +
+		"q1 not tab separated\n" + // Skipped by Procfile
+		m8("q1", "gi|2|", "x") +
+		"# Comment inside a query\n" +
+		"q1\tgi|3|\t100.00\n" +
+		m8("q2", "gi|4|", "40") +
+		"q2\tgi|5|\t100.00\t100\t0\t0\t1\t100\t1\t100\te-x\t30\n"
+	out := make(chan *BlastBlock, 16)
+	skipped, err := Procfile(bufio.NewReader(strings.NewReader(input)), out, false, nil)
+	if err != nil || skipped != 1 {
+		t.Fatalf("Procfile() = %d, %v, want 1 skipped line", skipped, err)
+	}
+	var got []string
+	for b := range out {
+		q := ParseRecordFilters(*b)
+		for _, perr := range q.Errors {
+			got = append(got, perr.Error())
+		}
+	}
+	want := []string{
+		"line 4: bit score: invalid number",
+		"line 6: less than 12 tab separated fields",
+		"line 8: e-value: invalid number",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRecordFilters() errors = %q, want %q", got, want)
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	tests := []struct {
+		perr *ParseError
+		want string
+	}{
+		{&ParseError{Line: 3, Err: ErrBlankQuery}, "line 3: blank query field"},
+		{&ParseError{Line: 3, Field: "e-value", Err: ErrBadNumber}, "line 3: e-value: invalid number"},
+		{&ParseError{Record: 7, Err: ErrBadRecord}, "record 7: malformed record"},
+		{&ParseError{Err: ErrFieldCount}, "line: less than 12 tab separated fields"},
+	}
+	for _, tt := range tests {
+		if got := tt.perr.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+		if !errors.Is(tt.perr, tt.perr.Err) {
+			t.Errorf("errors.Is(%q, %q) = false", tt.perr, tt.perr.Err)
+		}
+	}
+}
+
+func TestWarnCap(t *testing.T) {
+	logged := captureLog(t)
+	oldMax := MaxWarnings
+	MaxWarnings = 2
+	defer func() { MaxWarnings = oldMax }()
+	for i := 0; i < 5; i++ {
+		warn(&ParseError{Line: i + 1, Text: "bad", Err: ErrBlankQuery})
+	}
+	want := "WARNING: Ignoring malformed blast line (line 1: blank query field): bad\n" +
+		"WARNING: Ignoring malformed blast line (line 2: blank query field): bad\n" +
+		"WARNING: Too many malformed blast lines, only counting them from now on\n"
+	if got := logged.String(); got != want {
+		t.Errorf("warn() logged\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	logged := captureLog(t)
+	input := m8("q1", "gi|1|", "50") + m8("q1", "gi|2|", "40") + "q1 not tab separated\n" + m8("q2", "gi|3|", "30")
+	out := make(chan *BlastBlock, 16)
+	_, err := Procfile(bufio.NewReader(strings.NewReader(input)), out, true, nil)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 3 || !errors.Is(err, ErrNotTabSeparated) {
+		t.Errorf("Procfile() in strict mode error = %v, want a line 3 %q error", err, ErrNotTabSeparated)
+	}
+
+	oldStrict := StrictConvert
+	StrictConvert = true
+	defer func() { StrictConvert = oldStrict }()
+	q := ParseRecordFilters(BlastBlock{header: Header("q1"), block: []byte(m8("q1", "gi|1|", "x")), line: 1})
+	if len(q.Errors) != 1 || q.Errors[0].Line != 1 {
+		t.Errorf("ParseRecordFilters() errors = %v, want a line 1 error", q.Errors)
+	}
+	if logged.Len() > 0 {
+		t.Errorf("Malformed lines were logged in strict mode:\n%s", logged)
+	}
+}
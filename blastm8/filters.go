@@ -0,0 +1,145 @@
+package blastm8
+
+import (
+	"sort"
+)
+
+//HitFilter transforms the hits of a query (sorted by bit score) before the LCA calculation.
+//Filters must return the hits sorted by bit score
+type HitFilter func(Hits) Hits
+
+//HSP collapsing modes for CollapseHSPs
+const (
+	HSPSum = iota // Sum the bit scores of the HSPs over non-overlapping query intervals
+	HSPMax        // Keep the best HSP
+)
+
+//Score modes used for thresholding
+const (
+	ScoreBits      = iota // Bit score
+	ScorePerColumn        // Bit score per aligned column
+	ScorePerQlen          // Bit score per query length (estimated as the highest query coordinate of the hits)
+)
+
+//ScoreMode returns the score mode with the given name ("bits", "percol" or "perqlen")
+func ScoreMode(name string) (int, bool) {
+	switch name {
+	case "bits":
+		return ScoreBits, true
+	case "percol":
+		return ScorePerColumn, true
+	case "perqlen":
+		return ScorePerQlen, true
+	}
+	return -1, false
+}
+
+//scores returns the scores of the hits in the given mode.
+//Without aligned length (ScorePerColumn) or query coordinates (ScorePerQlen) the bit score is used
+func (hits Hits) scores(mode int) []float64 {
+	qlen := 0
+	if mode == ScorePerQlen {
+		for _, hit := range hits {
+			if _, hi := hit.span(); hi > qlen {
+				qlen = hi
+			}
+		}
+	}
+	scores := make([]float64, len(hits))
+	for i, hit := range hits {
+		switch {
+		case mode == ScorePerColumn && hit.alnlen > 0:
+			scores[i] = hit.bitsc / float64(hit.alnlen)
+		case mode == ScorePerQlen && qlen > 0:
+			scores[i] = hit.bitsc / float64(qlen)
+		default:
+			scores[i] = hit.bitsc
+		}
+	}
+	return scores
+}
+
+//keep returns the hits whose score passes the test (keeping their order)
+func (hits Hits) keep(mode int, test func(score, best float64) bool) Hits {
+	scores := hits.scores(mode)
+	best := float64(0)
+	for _, sc := range scores {
+		if sc > best {
+			best = sc
+		}
+	}
+	kept := hits[:0]
+	for i, hit := range hits {
+		if test(scores[i], best) {
+			kept = append(kept, hit)
+		}
+	}
+	return kept
+}
+
+//BitscoreFactor keeps the hits with bit score >= the best bit score * scLim
+func BitscoreFactor(scLim float64) HitFilter {
+	return func(hits Hits) Hits {
+		if len(hits) == 0 {
+			return hits
+		}
+		return hits[:hits.findIndex(hits[0].bitsc*scLim)]
+	}
+}
+
+//ScoreFactor keeps the hits with score >= the best score * scLim in the given score mode.
+//The query length is the same for all the hits of the query, so ScorePerQlen keeps the same hits as ScoreBits
+func ScoreFactor(mode int, scLim float64) HitFilter {
+	if mode == ScoreBits || mode == ScorePerQlen {
+		return BitscoreFactor(scLim)
+	}
+	return func(hits Hits) Hits {
+		return hits.keep(mode, func(score, best float64) bool { return score >= best*scLim })
+	}
+}
+
+//MinScore keeps the hits with score >= min in the given score mode
+func MinScore(mode int, min float64) HitFilter {
+	return func(hits Hits) Hits {
+		return hits.keep(mode, func(score, best float64) bool { return score >= min })
+	}
+}
+
+//CollapseHSPs merges the HSPs of each subject in a single hit (the best HSP).
+//In HSPSum mode its bit score is the sum of the bit scores of the HSPs that don't overlap
+//in the query with better HSPs. In HSPMax mode the best HSP is kept as is
+func CollapseHSPs(mode int) HitFilter {
+	return func(hits Hits) Hits {
+		bySubject := make(map[string]int, len(hits)) // subject => index in collapsed
+		spans := make(map[string][][2]int)
+		collapsed := make(Hits, 0, len(hits))
+		for _, hit := range hits { // Sorted by bit score, the first HSP of each subject is the best
+			subj := string(hit.subject)
+			lo, hi := hit.span()
+			i, ok := bySubject[subj]
+			if !ok {
+				best := *hit
+				bySubject[subj] = len(collapsed)
+				collapsed = append(collapsed, &best)
+				spans[subj] = [][2]int{{lo, hi}}
+				continue
+			}
+			if mode != HSPSum || overlaps(spans[subj], lo, hi) {
+				continue
+			}
+			collapsed[i].bitsc += hit.bitsc
+			spans[subj] = append(spans[subj], [2]int{lo, hi})
+		}
+		sort.Sort(collapsed)
+		return collapsed
+	}
+}
+
+func overlaps(spans [][2]int, lo, hi int) bool {
+	for _, sp := range spans {
+		if lo <= sp[1] && hi >= sp[0] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package blastm8
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+//hsp is an HSP of a subject over the query interval [qstart, qend]
+type hsp struct {
+	subject      string
+	bitsc        float64
+	qstart, qend int
+}
+
+//hspHits returns the hits of the HSPs (that must be sorted by bit score)
+func hspHits(hsps ...hsp) Hits {
+	hits := make(Hits, len(hsps))
+	for i, h := range hsps {
+		hits[i] = &Hit{gi: -1, subject: Header(h.subject), taxid: 100, bitsc: h.bitsc, qstart: h.qstart, qend: h.qend}
+	}
+	return hits
+}
+
+//subjectScores returns the hits as subject:bitscore
+func subjectScores(hits Hits) []string {
+	var desc []string
+	for _, hit := range hits {
+		desc = append(desc, fmt.Sprintf("%s:%g", hit.subject, hit.bitsc))
+	}
+	return desc
+}
+
+func TestCollapseHSPs(t *testing.T) {
+	hsps := []hsp{
+		{"A", 100, 1, 100},
+		{"B", 90, 1, 100},
+		{"A", 80, 201, 300},
+		{"A", 70, 50, 150}, // Overlaps the best HSP of A
+		{"C", 60, 1, 100},
+		{"C", 55, 300, 201},
+	}
+	tests := []struct {
+		name    string
+		filters []HitFilter
+		want    []string
+	}{
+		{
+			name:    "HSPs not collapsed",
+			filters: []HitFilter{BitscoreFactor(0.7)},
+			want:    []string{"A:100", "B:90", "A:80", "A:70"},
+		},
+		{
+			name:    "HSPMax",
+			filters: []HitFilter{CollapseHSPs(HSPMax)},
+			want:    []string{"A:100", "B:90", "C:60"},
+		},
+		{
+			name:    "HSPMax keeps a hit per subject",
+			filters: []HitFilter{CollapseHSPs(HSPMax), BitscoreFactor(0.6)},
+			want:    []string{"A:100", "B:90", "C:60"},
+		},
+		{
+			name:    "HSPSum",
+			filters: []HitFilter{CollapseHSPs(HSPSum)},
+			want:    []string{"A:180", "C:115", "B:90"},
+		},
+		{
+			name:    "HSPSum drops the subjects with a single HSP",
+			filters: []HitFilter{CollapseHSPs(HSPSum), BitscoreFactor(0.6)},
+			want:    []string{"A:180", "C:115"},
+		},
+		{
+			name:    "HSPSum with a single subject left",
+			filters: []HitFilter{CollapseHSPs(HSPSum), BitscoreFactor(0.9)},
+			want:    []string{"A:180"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := hspHits(hsps...)
+			got := hits
+			for _, f := range tt.filters {
+				got = f(got)
+			}
+			if desc := subjectScores(got); !reflect.DeepEqual(desc, tt.want) {
+				t.Errorf("Filtered hits = %q, want %q", desc, tt.want)
+			}
+			if hits[0].bitsc != 100 {
+				t.Errorf("CollapseHSPs() changed the bit score of the input hits")
+			}
+		})
+	}
+}
+
+//scoredHits returns hits of the given bit scores, aligned lengths and query ends (the query starts at 1 if qend > 0)
+func scoredHits(scores ...[3]float64) Hits {
+	hits := make(Hits, len(scores))
+	for i, sc := range scores {
+		hits[i] = &Hit{gi: -1, subject: Header(string(rune('A' + i))), taxid: 100, bitsc: sc[0], alnlen: int(sc[1]), qend: int(sc[2])}
+		if hits[i].qend > 0 {
+			hits[i].qstart = 1
+		}
+	}
+	return hits
+}
+
+func TestScoreMode(t *testing.T) {
+	for name, want := range map[string]int{"bits": ScoreBits, "percol": ScorePerColumn, "perqlen": ScorePerQlen} {
+		if mode, ok := ScoreMode(name); !ok || mode != want {
+			t.Errorf("ScoreMode(%q) = %d, %v, want %d, true", name, mode, ok, want)
+		}
+	}
+	for _, name := range []string{"", "Bits", "evalue"} {
+		if mode, ok := ScoreMode(name); ok {
+			t.Errorf("ScoreMode(%q) = %d, true, want an unknown mode", name, mode)
+		}
+	}
+}
+
+func TestScoreFilters(t *testing.T) {
+	hits := [][3]float64{ // Bit score, aligned length and query end
+		{100, 200, 200}, // 0.5 per column and 0.5 per query length
+		{90, 100, 100},  // 0.9 per column and 0.45 per query length
+		{60, 50, 50},    // 1.2 per column and 0.3 per query length
+		{40, 80, 40},    // 0.5 per column and 0.2 per query length
+	}
+	noCoords := [][3]float64{{100, 200, 0}, {90, 100, 0}, {60, 50, 0}}
+	noAlnlen := [][3]float64{{100, 200, 200}, {90, 0, 100}, {60, 50, 50}}
+	tests := []struct {
+		name   string
+		filter HitFilter
+		hits   [][3]float64
+		want   []string
+	}{
+		{"factor in bits", ScoreFactor(ScoreBits, 0.8), hits, []string{"A:100", "B:90"}},
+		{"factor per column", ScoreFactor(ScorePerColumn, 0.7), hits, []string{"B:90", "C:60"}},
+		{"factor per query length", ScoreFactor(ScorePerQlen, 0.8), hits, []string{"A:100", "B:90"}},
+		{"factor without hits", ScoreFactor(ScorePerColumn, 0.8), nil, nil},
+		{"minimum in bits", MinScore(ScoreBits, 60), hits, []string{"A:100", "B:90", "C:60"}},
+		{"minimum per column", MinScore(ScorePerColumn, 0.6), hits, []string{"B:90", "C:60"}},
+		{"minimum per query length", MinScore(ScorePerQlen, 0.4), hits, []string{"A:100", "B:90"}},
+		{"minimum per query length without coordinates", MinScore(ScorePerQlen, 60), noCoords, []string{"A:100", "B:90", "C:60"}},
+		{"minimum per column without aligned length", MinScore(ScorePerColumn, 1), noAlnlen, []string{"B:90", "C:60"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subjectScores(tt.filter(scoredHits(tt.hits...))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Filtered hits = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
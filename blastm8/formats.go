@@ -0,0 +1,124 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+//Converter converts an alignment file read from r to m8 lines (see InputFormat).
+//src is the same file for reading at random positions, or nil if it is not seekable (compressed or a pipe)
+type Converter func(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error
+
+//InputFormat is a supported alignment file format.
+//Every format is converted to m8 lines (12 columns and an optional 13th with the subject taxids) with the alignments
+//of each query together, so the blocks of its queries can be read with Procfile (or SortFile).
+//The queries without hits can be given as -outfmt 7 comment lines (see ConvertOutfmt7)
+type InputFormat struct {
+	Name    string
+	Convert Converter              // nil for m8, that is read natively
+	detect  func(head []byte) bool // Tells if the beginning of a file is in this format
+}
+
+//InputFormats are the supported input formats in auto-detection order (m8 is the fallback).
+//MMseqs2 files are only detected if they have a header line
+var InputFormats = []*InputFormat{
+	{Name: "daa", Convert: ConvertDAA, detect: isDAA},
+	{Name: "bam", Convert: ConvertBAM, detect: isBAM},
+	{Name: "xml", Convert: ConvertXML, detect: isXML},
+	{Name: "paf", Convert: ConvertPAF, detect: isPAF},
+	{Name: "sam", Convert: ConvertSAM, detect: isSAM},
+	{Name: "maf", Convert: ConvertMAF, detect: isMAF},
+	{Name: "lasttab", Convert: ConvertLastTab, detect: isLastTab},
+	{Name: "mmseqs", Convert: ConvertMMseqs, detect: isMMseqs},
+	{Name: "outfmt7", Convert: ConvertOutfmt7, detect: isOutfmt7},
+	{Name: "m8"},
+}
+
+//detectSize is the number of bytes looked at to detect the format of a file
+const detectSize = 4096
+
+//FormatByName returns the input format with the given name
+func FormatByName(name string) (*InputFormat, bool) {
+	for _, f := range InputFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+//DetectFormat guesses the format of the file from its beginning, without consuming it. Defaults to m8
+func DetectFormat(r *bufio.Reader) *InputFormat {
+	head, _ := r.Peek(detectSize)
+	for _, f := range InputFormats {
+		if f.detect != nil && f.detect(head) {
+			return f
+		}
+	}
+	m8, _ := FormatByName("m8")
+	return m8
+}
+
+//M8Reader returns a reader of the m8 lines of r (src as in Converter) and the conversion, that must be run in its
+//own goroutine while the reader is read (nil if the format is m8). The errors of the conversion are returned by the
+//reader and by the conversion. When quit is closed (it may be nil) the reader is closed and the conversion stops
+func (f *InputFormat) M8Reader(r *bufio.Reader, src io.ReaderAt, size int, quit <-chan struct{}) (*bufio.Reader, func() error) {
+	if f.Convert == nil {
+		return r, nil
+	}
+	pr, pw := io.Pipe()
+	convert := func() error {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-quit:
+				pr.Close()
+			case <-done:
+			}
+		}()
+		w := bufio.NewWriterSize(pw, size)
+		err := f.Convert(r, src, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		pw.CloseWithError(err)
+		return err
+	}
+	return bufio.NewReaderSize(pr, size), convert
+}
+
+//M8Line is a helper for the converters that appends an m8 line to buf.
+//mismatch and gapopen are not used by the LCA and can be 0 if the format doesn't have them.
+//taxid is written in the 13th column if it is > 0
+func M8Line(buf []byte, query, subject []byte, ident float64, alnlen, mismatch, gapopen, qstart, qend, sstart, send int, evalue, bitsc float64, taxid int) []byte {
+	buf = append(buf, query...)
+	buf = append(buf, '\t')
+	buf = append(buf, subject...)
+	buf = append(buf, '\t')
+	buf = strconv.AppendFloat(buf, ident, 'f', 2, 64)
+	for _, n := range []int{alnlen, mismatch, gapopen, qstart, qend, sstart, send} {
+		buf = append(buf, '\t')
+		buf = strconv.AppendInt(buf, int64(n), 10)
+	}
+	buf = append(buf, '\t')
+	buf = strconv.AppendFloat(buf, evalue, 'g', 3, 64)
+	buf = append(buf, '\t')
+	buf = strconv.AppendFloat(buf, bitsc, 'f', -1, 64)
+	if taxid > 0 {
+		buf = append(buf, '\t')
+		buf = strconv.AppendInt(buf, int64(taxid), 10)
+	}
+	return append(buf, '\n')
+}
+
+//firstWord returns the first space separated word of b
+func firstWord(b []byte) []byte {
+	b = bytes.TrimSpace(b)
+	if sp := bytes.IndexAny(b, " \t"); sp >= 0 {
+		return b[:sp]
+	}
+	return b
+}
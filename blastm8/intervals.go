@@ -0,0 +1,95 @@
+package blastm8
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+//span returns the query interval covered by the hit as 1-based closed coordinates with lo <= hi
+func (h *Hit) span() (lo, hi int) {
+	if h.qstart <= h.qend {
+		return h.qstart, h.qend
+	}
+	return h.qend, h.qstart
+}
+
+//intervalTaxa splits the query in intervals with a constant set of covering hits.
+//For each interval the LCA of the hits with score >= best score in the interval * scLim (in the given score mode)
+//is calculated and the covered length is added to its taxid in the returned map.
+//The intervals are swept in order keeping the hits that cover the current one, so only those are compared
+func intervalTaxa(taxDB *taxonomy.Taxonomy, hits Hits, mode int, scLim float64) map[int]float64 {
+	scores := hits.scores(mode)
+	los, his := make([]int, len(hits)), make([]int, len(hits))
+	order := make([]int, len(hits))
+	bounds := make([]int, 0, len(hits)*2)
+	for i, hit := range hits {
+		los[i], his[i] = hit.span()
+		order[i] = i
+		bounds = append(bounds, los[i], his[i]+1)
+	}
+	sort.Ints(bounds)
+	sort.Slice(order, func(a, b int) bool { return los[order[a]] < los[order[b]] })
+	weights := make(map[int]float64)
+	active := make([]int, 0, len(hits))
+	covering := make([]int, 0, len(hits))
+	next := 0
+	for i := 1; i < len(bounds); i++ {
+		from, to := bounds[i-1], bounds[i]
+		if from == to {
+			continue
+		}
+		for next < len(order) && los[order[next]] <= from {
+			active = append(active, order[next])
+			next++
+		}
+		// The bounds include every hit end, so a hit covering from covers the whole interval
+		k := 0
+		best := float64(0)
+		for _, j := range active {
+			if his[j] < from {
+				continue
+			}
+			active[k] = j
+			k++
+			if scores[j] > best {
+				best = scores[j]
+			}
+		}
+		active = active[:k]
+		covering = covering[:0]
+		for _, j := range active {
+			if scores[j] >= best*scLim {
+				covering = append(covering, hits[j].taxid)
+			}
+		}
+		lcaNode, err := taxDB.LCA(covering...)
+		if err != nil { // Not covered
+			continue
+		}
+		weights[lcaNode.Taxid] += float64(to - from)
+	}
+	return weights
+}
+
+//AssignIntervals is the interval-aware alternative to Assign intended for long reads and contigs, whose hits
+//cover different regions of the query (as in MEGAN-LR).
+//The query is split in intervals covered by the same hits and the LCA of each interval is calculated using the hits
+//with score >= the best score of the interval * scLim in the given score mode (see ScoreMode). The query is assigned to the deepest taxon covering
+//at least cover (0.5 < cover <= 1) of the total covered length.
+//q.Hits must not be filtered by score beforehand (see ParseRecord)
+func (q *QueryRes) AssignIntervals(taxDB *taxonomy.Taxonomy, filter *taxonomy.Filter, mode int, scLim, cover float64) error {
+	if cover <= 0.5 || cover > 1 {
+		return errors.New("Interval cover fraction must be in the (0.5, 1] range")
+	}
+	hits := q.mapHits(taxDB, filter)
+	weights := intervalTaxa(taxDB, hits, mode, scLim)
+	if len(weights) == 0 {
+		q.unassigned()
+		return errors.New("EMPTY")
+	}
+	q.Taxid = taxDB.WeightedLCA(weights, cover)
+	q.support(taxDB, hits)
+	return nil
+}
@@ -0,0 +1,196 @@
+package blastm8
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+//spanHit is a hit of a taxon over the query interval [qstart, qend]
+type spanHit struct {
+	taxid, qstart, qend int
+	bitsc               float64
+}
+
+//spanHits returns the hits of the spans, with the subjects s0, s1...
+func spanHits(spans ...spanHit) Hits {
+	hits := make(Hits, len(spans))
+	for i, sp := range spans {
+		hits[i] = &Hit{gi: -1, subject: Header(fmt.Sprintf("s%d", i)), taxid: sp.taxid, bitsc: sp.bitsc, qstart: sp.qstart, qend: sp.qend}
+	}
+	return hits
+}
+
+//naiveIntervalTaxa calculates the LCA of each query position on its own
+func naiveIntervalTaxa(taxDB *taxonomy.Taxonomy, hits Hits, mode int, scLim float64) map[int]float64 {
+	scores := hits.scores(mode)
+	qlen := 0
+	for _, hit := range hits {
+		if _, hi := hit.span(); hi > qlen {
+			qlen = hi
+		}
+	}
+	weights := make(map[int]float64)
+	for pos := 1; pos <= qlen; pos++ {
+		best := float64(0)
+		for j, hit := range hits {
+			if lo, hi := hit.span(); lo <= pos && pos <= hi && scores[j] > best {
+				best = scores[j]
+			}
+		}
+		var covering []int
+		for j, hit := range hits {
+			if lo, hi := hit.span(); lo <= pos && pos <= hi && scores[j] >= best*scLim {
+				covering = append(covering, hit.taxid)
+			}
+		}
+		if lcaNode, err := taxDB.LCA(covering...); err == nil {
+			weights[lcaNode.Taxid]++
+		}
+	}
+	return weights
+}
+
+func TestIntervalTaxa(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	tests := []struct {
+		name  string
+		spans []spanHit
+		scLim float64
+		want  map[int]float64
+	}{
+		{
+			name:  "overlapping",
+			spans: []spanHit{{100, 1, 100, 100}, {101, 51, 150, 100}},
+			scLim: 0.9,
+			want:  map[int]float64{100: 50, 10: 50, 101: 50},
+		},
+		{
+			name:  "nested hit with a better score",
+			spans: []spanHit{{100, 1, 300, 50}, {300, 101, 200, 100}},
+			scLim: 0.9,
+			want:  map[int]float64{100: 200, 300: 100},
+		},
+		{
+			name:  "nested hit within the score limit",
+			spans: []spanHit{{100, 1, 300, 50}, {300, 101, 200, 100}},
+			scLim: 0.4,
+			want:  map[int]float64{100: 200, 1: 100},
+		},
+		{
+			name:  "nested hit ending first",
+			spans: []spanHit{{11, 1, 20, 100}, {100, 5, 50, 100}, {101, 10, 15, 100}},
+			scLim: 1,
+			want:  map[int]float64{11: 4, 2: 16, 100: 30},
+		},
+		{
+			name:  "reverse strand",
+			spans: []spanHit{{100, 100, 1, 100}},
+			scLim: 1,
+			want:  map[int]float64{100: 100},
+		},
+		{
+			name:  "gap between hits",
+			spans: []spanHit{{100, 1, 10, 100}, {101, 21, 30, 100}},
+			scLim: 1,
+			want:  map[int]float64{100: 10, 101: 10},
+		},
+		{
+			name:  "same interval",
+			spans: []spanHit{{100, 1, 10, 100}, {100, 1, 10, 90}},
+			scLim: 0.5,
+			want:  map[int]float64{100: 10},
+		},
+		{
+			name:  "not in the taxonomy",
+			spans: []spanHit{{999, 1, 10, 100}},
+			scLim: 1,
+			want:  map[int]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := intervalTaxa(taxDB, spanHits(tt.spans...), ScoreBits, tt.scLim)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intervalTaxa() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//randomSpans returns n hits of random taxa of testNodes over a query of length qlen
+func randomSpans(rng *rand.Rand, n, qlen int) Hits {
+	spans := make([]spanHit, n)
+	for i := range spans {
+		lo := 1 + rng.Intn(qlen)
+		hi := lo + rng.Intn(qlen/4+1)
+		if hi > qlen {
+			hi = qlen
+		}
+		if rng.Intn(2) == 0 {
+			lo, hi = hi, lo
+		}
+		spans[i] = spanHit{testNodes[rng.Intn(len(testNodes))].taxid, lo, hi, float64(50 + rng.Intn(50))}
+	}
+	return spanHits(spans...)
+}
+
+func TestIntervalTaxaRandom(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		hits := randomSpans(rng, 1+rng.Intn(20), 200)
+		got := intervalTaxa(taxDB, hits, ScoreBits, 0.8)
+		if want := naiveIntervalTaxa(taxDB, hits, ScoreBits, 0.8); !reflect.DeepEqual(got, want) {
+			t.Fatalf("intervalTaxa() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAssignIntervals(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	nested := []spanHit{{100, 1, 300, 50}, {300, 101, 200, 100}}
+	tests := []struct {
+		name  string
+		spans []spanHit
+		cover float64
+		want  int
+		err   string
+	}{
+		{name: "deepest taxon covering the fraction", spans: nested, cover: 0.6, want: 100},
+		{name: "no taxon but the root covers the fraction", spans: nested, cover: 0.9, want: 1},
+		{name: "overlapping siblings", spans: []spanHit{{100, 1, 100, 100}, {101, 51, 150, 100}}, cover: 0.6, want: 10},
+		{name: "not in the taxonomy", spans: []spanHit{{999, 1, 10, 100}}, cover: 0.6, want: -1, err: "EMPTY"},
+		{name: "invalid cover fraction", spans: nested, cover: 0.5, err: "Interval cover fraction must be in the (0.5, 1] range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &QueryRes{Query: Header("q1"), Hits: spanHits(tt.spans...), Parsed: len(tt.spans)}
+			err := q.AssignIntervals(taxDB, nil, ScoreBits, 0.9, tt.cover)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("AssignIntervals() error = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("AssignIntervals() error = %v", err)
+			}
+			if q.Taxid != tt.want {
+				t.Errorf("AssignIntervals() taxid = %d, want %d", q.Taxid, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkIntervalTaxa(b *testing.B) {
+	taxDB := testTaxonomy(b)
+	hits := randomSpans(rand.New(rand.NewSource(1)), 2000, 50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		intervalTaxa(taxDB, hits, ScoreBits, 0.9)
+	}
+}
+
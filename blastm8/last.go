@@ -0,0 +1,307 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+//LAST (lastal) writes the reference (subject) first and the query second. Its coordinates are 0-based and,
+//in the - strand, relative to the reverse complement of the sequence. LAST gives raw scores, not bit scores,
+//that are used as the bit scores of the hits
+
+//lastSeq is the aligned part of a sequence of a LAST alignment
+type lastSeq struct {
+	name                 []byte
+	start, size, seqSize int
+	reverse              bool
+}
+
+//parse reads the name, start, size, strand and sequence size columns
+func (s *lastSeq) parse(fields [][]byte) error {
+	var err [3]error
+	s.name = fields[0]
+	s.start, err[0] = atoi(fields[1])
+	s.size, err[1] = atoi(fields[2])
+	s.seqSize, err[2] = atoi(fields[4])
+	for _, e := range err {
+		if e != nil {
+			return ErrBadNumber
+		}
+	}
+	if len(fields[3]) != 1 || (fields[3][0] != '+' && fields[3][0] != '-') {
+		return ErrBadNumber
+	}
+	s.reverse = fields[3][0] == '-'
+	return nil
+}
+
+//coords returns the 1-based coordinates of the aligned part in the + strand
+func (s *lastSeq) coords() (int, int) {
+	if s.reverse {
+		return s.seqSize - s.start - s.size + 1, s.seqSize - s.start
+	}
+	return s.start + 1, s.start + s.size
+}
+
+//lastAlignment is an alignment of a LAST file
+type lastAlignment struct {
+	score, evalue             float64
+	subject, query            lastSeq
+	alnlen, mismatch, gapopen int
+	ident                     float64
+}
+
+//m8Line appends the alignment as an m8 line to buf. The subject coordinates are reversed if the strands differ
+func (a *lastAlignment) m8Line(buf []byte) []byte {
+	qstart, qend := a.query.coords()
+	sstart, send := a.subject.coords()
+	if a.query.reverse != a.subject.reverse {
+		sstart, send = send, sstart
+	}
+	return M8Line(buf, a.query.name, a.subject.name, a.ident, a.alnlen, a.mismatch, a.gapopen,
+		qstart, qend, sstart, send, a.evalue, a.score, 0)
+}
+
+//parseLastFields reads the score (score=) and E-value (E=) of the key=value fields of a LAST alignment
+func (a *lastAlignment) parseLastFields(fields [][]byte) error {
+	for _, f := range fields {
+		eq := bytes.IndexByte(f, '=')
+		if eq < 0 {
+			continue
+		}
+		var err error
+		switch string(f[:eq]) {
+		case "score":
+			a.score, err = atof(f[eq+1:])
+		case "E":
+			a.evalue, err = atof(f[eq+1:])
+		}
+		if err != nil {
+			return ErrBadNumber
+		}
+	}
+	return nil
+}
+
+//firstDataLine returns the first line of head that is not a comment
+func firstDataLine(head []byte) []byte {
+	for len(head) > 0 {
+		line := head
+		nl := bytes.IndexByte(head, '\n')
+		if nl >= 0 {
+			line, head = head[:nl], head[nl+1:]
+		} else {
+			head = nil
+		}
+		if len(line) > 0 && line[0] != '#' {
+			return line
+		}
+	}
+	return nil
+}
+
+//isMAF tells if the file starts like a MAF file (with or without the comments written by lastal)
+func isMAF(head []byte) bool {
+	if bytes.HasPrefix(head, []byte("##maf")) {
+		return true
+	}
+	return bytes.HasPrefix(firstDataLine(head), []byte("a "))
+}
+
+//isLastTab tells if the file starts like a LAST tabular file: 12 or more columns with a score and the strands
+//in the 5th and 10th columns
+func isLastTab(head []byte) bool {
+	fields := bytes.Split(firstDataLine(head), []byte{'\t'})
+	if len(fields) < 12 {
+		return false
+	}
+	for _, i := range []int{4, 9} {
+		if len(fields[i]) != 1 || (fields[i][0] != '+' && fields[i][0] != '-') {
+			return false
+		}
+	}
+	_, err := atof(fields[0])
+	return err == nil
+}
+
+//ConvertLastTab converts a LAST tabular file (lastal -f TAB or maf-convert tab) to m8 lines.
+//The aligned length and gap openings come from the blocks column, the identity is not given by this format (0).
+//Malformed lines are skipped unless StrictConvert is set
+func ConvertLastTab(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	var scratch, line []byte
+	fields := make([][]byte, 0, 16)
+	var a lastAlignment
+	for lineno := 1; ; lineno++ {
+		text, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		fields = splitFields(text, fields)
+		if perr := a.parseTab(fields); perr != nil {
+			perr.Line, perr.Text = lineno, string(text)
+			if err := skipRecord(perr); err != nil {
+				return err
+			}
+			continue
+		}
+		line = a.m8Line(line[:0])
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+//parseTab reads the alignment from the fields of a LAST tabular line.
+//The returned error lacks the line number and text
+func (a *lastAlignment) parseTab(fields [][]byte) *ParseError {
+	if len(fields) < 12 {
+		return &ParseError{Err: ErrFieldCount}
+	}
+	*a = lastAlignment{}
+	var err error
+	if a.score, err = atof(fields[0]); err != nil {
+		return &ParseError{Field: "score", Err: ErrBadNumber}
+	}
+	if a.subject.parse(fields[1:6]) != nil || a.query.parse(fields[6:11]) != nil || a.parseBlocks(fields[11]) != nil {
+		return &ParseError{Err: ErrBadNumber}
+	}
+	if err := a.parseLastFields(fields[12:]); err != nil {
+		return &ParseError{Field: "E-value", Err: err}
+	}
+	return nil
+}
+
+//parseBlocks reads the aligned length and gap openings from the blocks of the alignment
+//(comma separated ungapped sizes and subject:query gaps)
+func (a *lastAlignment) parseBlocks(b []byte) error {
+	for _, block := range bytes.Split(b, []byte{','}) {
+		if colon := bytes.IndexByte(block, ':'); colon >= 0 {
+			sgap, err1 := atoi(block[:colon])
+			qgap, err2 := atoi(block[colon+1:])
+			if err1 != nil || err2 != nil {
+				return ErrBadNumber
+			}
+			for _, gap := range []int{sgap, qgap} {
+				if gap > 0 {
+					a.gapopen++
+					a.alnlen += gap
+				}
+			}
+			continue
+		}
+		size, err := atoi(block)
+		if err != nil {
+			return ErrBadNumber
+		}
+		a.alnlen += size
+	}
+	return nil
+}
+
+//compare sets the aligned length, mismatches, gap openings and identity of the alignment from its aligned sequences
+func (a *lastAlignment) compare(subject, query []byte) {
+	if len(query) < len(subject) {
+		subject = subject[:len(query)]
+	}
+	a.alnlen = len(subject)
+	identities := 0
+	sgap, qgap := false, false
+	for i := range subject {
+		s, q := subject[i]|0x20, query[i]|0x20 // Lower case ('-' is not changed)
+		switch {
+		case s == '-':
+			if !sgap {
+				a.gapopen++
+			}
+		case q == '-':
+			if !qgap {
+				a.gapopen++
+			}
+		case s == q:
+			identities++
+		default:
+			a.mismatch++
+		}
+		sgap, qgap = s == '-', q == '-'
+	}
+	if a.alnlen > 0 {
+		a.ident = 100 * float64(identities) / float64(a.alnlen)
+	}
+}
+
+//ConvertMAF converts a MAF file (lastal default output) to m8 lines. The first sequence of each alignment is
+//the subject and the second one the query. Alignments with malformed lines are skipped unless StrictConvert is set
+func ConvertMAF(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	var scratch, line []byte
+	var a lastAlignment
+	var seqs [2][]byte
+	nseqs, inAlignment := 0, false
+	flush := func() error {
+		if !inAlignment || nseqs < 2 {
+			return nil
+		}
+		a.compare(seqs[0], seqs[1])
+		line = a.m8Line(line[:0])
+		_, err := w.Write(line)
+		return err
+	}
+	for lineno := 1; ; lineno++ {
+		text, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		fields := bytes.Fields(text)
+		if len(fields) == 0 || text[0] == '#' {
+			continue
+		}
+		switch string(fields[0]) {
+		case "a":
+			if err := flush(); err != nil {
+				return err
+			}
+			a = lastAlignment{}
+			nseqs, inAlignment = 0, true
+			if err := a.parseLastFields(fields[1:]); err != nil {
+				inAlignment = false
+				if err := skipRecord(&ParseError{Line: lineno, Field: "score", Text: string(text), Err: err}); err != nil {
+					return err
+				}
+			}
+		case "s":
+			if !inAlignment || nseqs >= 2 {
+				continue // Only pairwise alignments are used
+			}
+			if len(fields) < 7 {
+				inAlignment = false
+				if err := skipRecord(&ParseError{Line: lineno, Text: string(text), Err: ErrFieldCount}); err != nil {
+					return err
+				}
+				continue
+			}
+			seq := &a.subject
+			if nseqs == 1 {
+				seq = &a.query
+			}
+			if err := seq.parse(fields[1:6]); err != nil {
+				inAlignment = false
+				if err := skipRecord(&ParseError{Line: lineno, Text: string(text), Err: err}); err != nil {
+					return err
+				}
+				continue
+			}
+			seq.name = append([]byte(nil), seq.name...) // text is reused by the next line
+			seqs[nseqs] = append(seqs[nseqs][:0], fields[6]...)
+			nseqs++
+		}
+	}
+}
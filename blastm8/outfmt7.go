@@ -0,0 +1,139 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+//BLAST tabular files with comment lines (-outfmt 7) have these lines before the hits of each query:
+//
+// # BLASTN 2.12.0+
+// # Query: q1 description
+// # Database: nt
+// # Fields: query acc.ver, subject acc.ver, % identity, ...
+// # 2 hits found
+//
+//Queries without hits have a "# 0 hits found" line and no Fields line. Procfile and SortFile skip the comment lines
+//of m8 files and pass the queries without hits as blocks without lines, the converters of other formats write
+//these two lines for their queries without hits (see noHitsLines)
+
+const (
+	commentQuery  = "# Query: "
+	commentFields = "# Fields: "
+	commentNoHits = "# 0 hits found"
+)
+
+var outfmt7Rx = regexp.MustCompile(`^# [A-Z]*BLAST[A-Z]* `)
+
+//blastFields are the field names of the Fields line of -outfmt 7 (and the names given to -outfmt) that are used
+var blastFields = map[string]tabField{
+	"query id":         {colQuery, 1},
+	"query acc.":       {colQuery, 1},
+	"query acc.ver":    {colQuery, 1},
+	"qseqid":           {colQuery, 1},
+	"qacc":             {colQuery, 1},
+	"qaccver":          {colQuery, 1},
+	"subject id":       {colSubject, 1},
+	"subject acc.":     {colSubject, 1},
+	"subject acc.ver":  {colSubject, 1},
+	"sseqid":           {colSubject, 1},
+	"sacc":             {colSubject, 1},
+	"saccver":          {colSubject, 1},
+	"% identity":       {colIdent, 1},
+	"pident":           {colIdent, 1},
+	"alignment length": {colAlnLen, 1},
+	"length":           {colAlnLen, 1},
+	"mismatches":       {colMismatch, 1},
+	"mismatch":         {colMismatch, 1},
+	"gap opens":        {colGapOpen, 1},
+	"gapopen":          {colGapOpen, 1},
+	"q. start":         {colQStart, 1},
+	"qstart":           {colQStart, 1},
+	"q. end":           {colQEnd, 1},
+	"qend":             {colQEnd, 1},
+	"s. start":         {colSStart, 1},
+	"sstart":           {colSStart, 1},
+	"s. end":           {colSEnd, 1},
+	"send":             {colSEnd, 1},
+	"evalue":           {colEvalue, 1},
+	"bit score":        {colBitsc, 1},
+	"bitscore":         {colBitsc, 1},
+	"subject tax ids":  {colTaxid, 1},
+	"staxids":          {colTaxid, 1},
+	"subject tax id":   {colTaxid, 1},
+	"staxid":           {colTaxid, 1},
+}
+
+//isComment tells if an m8 line is a comment
+func isComment(line []byte) bool {
+	return len(line) > 0 && line[0] == '#'
+}
+
+//noHitsTracker follows the comment lines of an m8 file to find the queries without hits
+type noHitsTracker struct {
+	query []byte
+}
+
+//comment reads a comment line. It returns the query (a new slice) if the line tells that it has no hits
+func (t *noHitsTracker) comment(line []byte) []byte {
+	switch {
+	case bytes.HasPrefix(line, []byte(commentQuery)):
+		t.query = append(t.query[:0], firstWord(line[len(commentQuery):])...)
+	case bytes.HasPrefix(line, []byte(commentNoHits)) && len(t.query) > 0:
+		query := append([]byte(nil), t.query...)
+		t.query = t.query[:0]
+		return query
+	}
+	return nil
+}
+
+//noHitsLines appends the comment lines of a query without hits to buf
+func noHitsLines(buf, query []byte) []byte {
+	buf = append(buf, commentQuery...)
+	buf = append(buf, query...)
+	buf = append(buf, '\n')
+	buf = append(buf, commentNoHits...)
+	return append(buf, '\n')
+}
+
+//isOutfmt7 tells if the file starts like a BLAST -outfmt 7 file
+func isOutfmt7(head []byte) bool {
+	return outfmt7Rx.Match(head)
+}
+
+//ConvertOutfmt7 converts a BLAST -outfmt 7 file to m8 lines. The columns of the hits are taken from the Fields
+//line of each query, so any field list with the query, the subject and the bit score is understood. The comment lines
+//are kept, so the line numbers don't change and the queries without hits are found by Procfile and SortFile
+func ConvertOutfmt7(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	cols, _ := newTabColumns(strings.Split("qseqid sseqid pident length mismatch gapopen qstart qend sstart send evalue bitscore", " "), blastFields)
+	var scratch, line []byte
+	fields := make([][]byte, 0, 16)
+	for lineno := 1; ; lineno++ {
+		text, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isComment(text) {
+			if bytes.HasPrefix(text, []byte(commentFields)) {
+				if cols, err = newTabColumns(strings.Split(string(text[len(commentFields):]), ","), blastFields); err != nil {
+					return &ParseError{Line: lineno, Text: string(text), Err: err}
+				}
+			}
+			line = append(append(line[:0], text...), '\n')
+		} else {
+			fields = splitFields(text, fields)
+			if line, err = cols.m8Line(line[:0], fields); err != nil {
+				line = append(append(line[:0], text...), '\n') // Malformed lines are reported by Procfile
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+//isPAF tells if the file starts like a PAF file: 12 or more columns with the strand in the 5th and numbers in the rest
+func isPAF(head []byte) bool {
+	line := head
+	if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	fields := bytes.Split(line, []byte{'\t'})
+	if len(fields) < 12 || len(fields[4]) != 1 || (fields[4][0] != '+' && fields[4][0] != '-') {
+		return false
+	}
+	for _, i := range []int{1, 2, 3, 6, 7, 8, 9, 10, 11} {
+		if _, err := atoi(fields[i]); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+//ConvertPAF converts a PAF file (minimap2) to m8 lines. The score is the AS tag, or the number of matching bases if
+//there is none, and the identity is the fraction of matching bases in the alignment block.
+//The alignments must be grouped by query, as written by minimap2. Malformed lines are skipped unless StrictConvert is set
+func ConvertPAF(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	var scratch, line []byte
+	fields := make([][]byte, 0, 24)
+	var nums [9]int
+	for lineno := 1; ; lineno++ {
+		text, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		fields = splitFields(text, fields)
+		var perr *ParseError
+		if len(fields) < 12 {
+			perr = &ParseError{Line: lineno, Text: string(text), Err: ErrFieldCount}
+		} else {
+			for n, i := range []int{1, 2, 3, 6, 7, 8, 9, 10, 11} {
+				if nums[n], err = atoi(fields[i]); err != nil {
+					perr = &ParseError{Line: lineno, Text: string(text), Err: ErrBadNumber}
+					break
+				}
+			}
+		}
+		if perr != nil {
+			if err := skipRecord(perr); err != nil {
+				return err
+			}
+			continue
+		}
+		qstart, qend, tstart, tend, matches, blockLen := nums[1], nums[2], nums[4], nums[5], nums[6], nums[7]
+		score := float64(matches)
+		for _, tag := range fields[12:] {
+			if bytes.HasPrefix(tag, []byte("AS:i:")) {
+				if as, err := atoi(tag[5:]); err == nil {
+					score = float64(as)
+				}
+			}
+		}
+		ident := 0.0
+		if blockLen > 0 {
+			ident = 100 * float64(matches) / float64(blockLen)
+		}
+		sstart, send := tstart+1, tend
+		if fields[4][0] == '-' {
+			sstart, send = send, sstart
+		}
+		line = M8Line(line[:0], fields[0], fields[5], ident, blockLen, blockLen-matches, 0,
+			qstart+1, qend, sstart, send, 0, score, 0)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package blastm8
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+//MateName splits a query header in its fragment name and mate number (1 or 2).
+//Mate suffixes can be "/1" and "/2" or Illumina/Casava comments (" 1:N:0:ACGT").
+//If no mate suffix is found the header is returned unchanged with mate 0
+func MateName(h Header) (Header, int) {
+	if sp := bytes.IndexAny(h, " \t"); sp > 0 && sp+2 < len(h) && h[sp+2] == ':' {
+		switch h[sp+1] {
+		case '1':
+			return h[:sp], 1
+		case '2':
+			return h[:sp], 2
+		}
+	}
+	if l := len(h); l > 2 && h[l-2] == '/' {
+		switch h[l-1] {
+		case '1':
+			return h[:l-2], 1
+		case '2':
+			return h[:l-2], 2
+		}
+	}
+	return h, 0
+}
+
+//Mate returns the block of the other mate of the fragment (nil if unpaired)
+func (b *BlastBlock) Mate() *BlastBlock {
+	return b.mate
+}
+
+type pendingMate struct {
+	block *BlastBlock
+	mate  int
+	seq   int
+}
+
+//PairMates reads the blocks from inChan, links the blocks of both mates of each fragment and passes them to outChan
+//with the fragment name as header (the second mate is reachable from the first via Mate).
+//Mates don't need to be adjacent in the input. Blocks without mate suffix are passed straight away,
+//mates whose pair is not found are passed at the end in input order. It stops when quit is closed (it may be nil).
+//outChan is closed at the end
+func PairMates(inChan <-chan *BlastBlock, outChan chan<- *BlastBlock, quit <-chan struct{}) {
+	defer close(outChan)
+	pending := make(map[string]*pendingMate)
+	seq := 0
+	for b := range inChan {
+		frag, mate := MateName(b.header)
+		if mate == 0 {
+			if !send(outChan, b, quit) {
+				return
+			}
+			continue
+		}
+		b.header = frag
+		if p, ok := pending[string(frag)]; ok && p.mate != mate {
+			delete(pending, string(frag))
+			first := p.block
+			if mate == 1 {
+				b.mate, first = p.block, b
+			} else {
+				p.block.mate = b
+			}
+			if !send(outChan, first, quit) {
+				return
+			}
+			continue
+		} else if ok && !send(outChan, p.block, quit) { // Same mate twice, the first one is unpaired
+			return
+		}
+		pending[string(frag)] = &pendingMate{block: b, mate: mate, seq: seq}
+		seq++
+	}
+	orphans := make([]*pendingMate, 0, len(pending))
+	for _, p := range pending {
+		orphans = append(orphans, p)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].seq < orphans[j].seq })
+	for _, p := range orphans {
+		if !send(outChan, p.block, quit) {
+			return
+		}
+	}
+}
+
+//MergeMates combines the results of both mates of a fragment in m1.
+//In union mode all the hits of both mates are kept.
+//In intersection mode only the hits to taxids hit by both mates are kept (if one mate has no hits, the hits of the other are used).
+//taxDB is only used in intersection mode
+func MergeMates(m1, m2 *QueryRes, taxDB *taxonomy.Taxonomy, intersect bool) *QueryRes {
+	m1.Parsed += m2.Parsed
+	if !intersect || len(m1.Hits) == 0 || len(m2.Hits) == 0 {
+		m1.Hits = append(m1.Hits, m2.Hits...)
+		sort.Sort(m1.Hits)
+		return m1
+	}
+	taxa1 := mateTaxa(m1.Hits, taxDB)
+	taxa2 := mateTaxa(m2.Hits, taxDB)
+	hits := make(Hits, 0, len(m1.Hits)+len(m2.Hits))
+	for i, hit := range m1.Hits {
+		if t := taxa1[i]; t > 0 && inTaxa(taxa2, t) {
+			hits = append(hits, hit)
+		}
+	}
+	for i, hit := range m2.Hits {
+		if t := taxa2[i]; t > 0 && inTaxa(taxa1, t) {
+			hits = append(hits, hit)
+		}
+	}
+	sort.Sort(hits)
+	m1.Hits = hits
+	return m1
+}
+
+//mateTaxa returns the taxids of the hits (0 if unknown): the ones given by the input or else the ones of their GIs
+func mateTaxa(hits Hits, taxDB *taxonomy.Taxonomy) []int {
+	taxa := make([]int, len(hits))
+	for i, hit := range hits {
+		if hit.taxid != 0 {
+			taxa[i] = hit.taxid
+		} else if taxid, err := taxDB.TaxidFromGi(hit.gi); err == nil {
+			taxa[i] = taxid
+		}
+	}
+	return taxa
+}
+
+func inTaxa(taxa []int, taxid int) bool {
+	for _, t := range taxa {
+		if t == taxid {
+			return true
+		}
+	}
+	return false
+}
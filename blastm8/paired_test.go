@@ -0,0 +1,166 @@
+package blastm8
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMateName(t *testing.T) {
+	tests := []struct {
+		header string
+		frag   string
+		mate   int
+	}{
+		{"r1/1", "r1", 1},
+		{"r1/2", "r1", 2},
+		{"r1 1:N:0:ACGT", "r1", 1},
+		{"r1 2:Y:18:1", "r1", 2},
+		{"r1\t1:N:0:ACGT", "r1", 1},
+		{"r1 1:N:0:ACGT/2", "r1", 1},
+		{"r1/3", "r1/3", 0},
+		{"r1 3:N:0:ACGT", "r1 3:N:0:ACGT", 0},
+		{"r1 desc", "r1 desc", 0},
+		{"/1", "/1", 0},
+		{"r1", "r1", 0},
+	}
+	for _, tt := range tests {
+		frag, mate := MateName(Header(tt.header))
+		if string(frag) != tt.frag || mate != tt.mate {
+			t.Errorf("MateName(%q) = %q, %d, want %q, %d", tt.header, frag, mate, tt.frag, tt.mate)
+		}
+	}
+}
+
+func TestPairMates(t *testing.T) {
+	tests := []struct {
+		name  string
+		input [][2]string // Header and block
+		want  []string
+	}{
+		{
+			name:  "adjacent",
+			input: [][2]string{{"r1/1", "a"}, {"r1/2", "b"}},
+			want:  []string{"r1: a + b"},
+		},
+		{
+			name:  "second mate first",
+			input: [][2]string{{"r1/2", "b"}, {"r1/1", "a"}},
+			want:  []string{"r1: a + b"},
+		},
+		{
+			name:  "illumina comments",
+			input: [][2]string{{"r1 1:N:0:ACGT", "a"}, {"r1 2:N:0:ACGT", "b"}},
+			want:  []string{"r1: a + b"},
+		},
+		{
+			name:  "not adjacent",
+			input: [][2]string{{"r1/1", "a"}, {"r2/1", "c"}, {"r1/2", "b"}, {"r3", "d"}, {"r2/2", "e"}},
+			want:  []string{"r1: a + b", "r3: d", "r2: c + e"},
+		},
+		{
+			name:  "unpaired mates at the end",
+			input: [][2]string{{"r2/2", "b"}, {"r0", "z"}, {"r1/1", "a"}},
+			want:  []string{"r0: z", "r2: b", "r1: a"},
+		},
+		{
+			name:  "same mate twice",
+			input: [][2]string{{"r1/1", "a"}, {"r1/1", "c"}, {"r1/2", "b"}},
+			want:  []string{"r1: a", "r1: c + b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := make(chan *BlastBlock, len(tt.input))
+			for _, b := range tt.input {
+				in <- &BlastBlock{header: Header(b[0]), block: []byte(b[1])}
+			}
+			close(in)
+			out := make(chan *BlastBlock, len(tt.input))
+			PairMates(in, out, nil)
+			var got []string
+			for b := range out {
+				desc := string(b.header) + ": " + string(b.block)
+				if m := b.Mate(); m != nil {
+					desc += " + " + string(m.block)
+				}
+				got = append(got, desc)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PairMates() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPairMatesQuit(t *testing.T) {
+	in := make(chan *BlastBlock, 1)
+	in <- &BlastBlock{header: Header("r1")}
+	close(in)
+	quit := make(chan struct{})
+	close(quit)
+	out := make(chan *BlastBlock) // Nobody reads it
+	PairMates(in, out, quit)
+	if _, ok := <-out; ok {
+		t.Errorf("PairMates() sent a block after quit was closed")
+	}
+}
+
+func TestMergeMates(t *testing.T) {
+	taxDB := testTaxonomy(t)
+	//mates returns the results of both mates, with the hits of the second mate interleaved in score with the first
+	mates := func(taxa1, taxa2 []int) (*QueryRes, *QueryRes) {
+		m1 := &QueryRes{Query: Header("r1"), Hits: taxidHits(taxa1...), Parsed: len(taxa1)}
+		m2 := &QueryRes{Query: Header("r1"), Hits: taxidHits(taxa2...), Parsed: len(taxa2)}
+		for _, hit := range m2.Hits {
+			hit.subject = append(Header("t"), hit.subject[1:]...)
+			hit.bitsc -= 0.5
+		}
+		return m1, m2
+	}
+	tests := []struct {
+		name         string
+		taxa1, taxa2 []int
+		intersect    bool
+		want         []string // Subject:taxid
+	}{
+		{
+			name:  "union",
+			taxa1: []int{100, 101, 300}, taxa2: []int{101, 11},
+			want: []string{"s0:100", "t0:101", "s1:101", "t1:11", "s2:300"},
+		},
+		{
+			name:  "intersection",
+			taxa1: []int{100, 101, 300}, taxa2: []int{101, 11},
+			intersect: true,
+			want:      []string{"t0:101", "s1:101"},
+		},
+		{
+			name:  "intersection with an empty mate",
+			taxa1: []int{100, 101}, taxa2: nil,
+			intersect: true,
+			want:      []string{"s0:100", "s1:101"},
+		},
+		{
+			name:  "intersection without common taxa",
+			taxa1: []int{100}, taxa2: []int{101},
+			intersect: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m1, m2 := mates(tt.taxa1, tt.taxa2)
+			q := MergeMates(m1, m2, taxDB, tt.intersect)
+			var got []string
+			for _, hit := range q.Hits {
+				got = append(got, fmt.Sprintf("%s:%d", hit.subject, hit.taxid))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeMates() hits = %q, want %q", got, tt.want)
+			}
+			if want := len(tt.taxa1) + len(tt.taxa2); q.Parsed != want {
+				t.Errorf("MergeMates() parsed %d hits, want %d", q.Parsed, want)
+			}
+		})
+	}
+}
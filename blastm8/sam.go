@@ -0,0 +1,442 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+//SAM flags used by the converters
+const (
+	samPaired   = 0x1
+	samUnmapped = 0x4
+	samReverse  = 0x10
+	samMate1    = 0x40
+	samMate2    = 0x80
+)
+
+//SAMScore is the score given to SAM/BAM alignments: the alignment score ("AS" tag, or MAPQ if there is none) or
+//the mapping quality ("MAPQ"). Alignment scores <= 0 (end-to-end modes, where the best score is 0) are added to the
+//aligned length of the read to get positive scores
+var SAMScore = "AS"
+
+const cigarOps = "MIDNSHP=X"
+
+var cigarRx = regexp.MustCompile(`^(\*|([0-9]+[MIDNSHP=X])+)$`)
+
+type cigarOp struct {
+	op  byte
+	len int
+}
+
+//samRecord is an alignment of a SAM or BAM file
+type samRecord struct {
+	qname, rname []byte
+	flag         int
+	pos, mapq    int
+	cigar        []cigarOp
+	as, nm       int
+	hasAS, hasNM bool
+}
+
+//m8Line appends the record as an m8 line to buf
+func (rec *samRecord) m8Line(buf []byte) []byte {
+	readLen, clip, qspan, rspan, alnlen, indels, gapopen := 0, 0, 0, 0, 0, 0, 0
+	for i, c := range rec.cigar {
+		switch c.op {
+		case 'M', '=', 'X':
+			qspan += c.len
+			rspan += c.len
+			alnlen += c.len
+			readLen += c.len
+		case 'I':
+			qspan += c.len
+			alnlen += c.len
+			indels += c.len
+			gapopen++
+			readLen += c.len
+		case 'D':
+			rspan += c.len
+			alnlen += c.len
+			indels += c.len
+			gapopen++
+		case 'N':
+			rspan += c.len
+		case 'S', 'H':
+			if qspan == 0 && i < 2 { // Leading clip
+				clip += c.len
+			}
+			readLen += c.len
+		}
+	}
+	qstart, qend := clip+1, clip+qspan
+	sstart, send := rec.pos, rec.pos+rspan-1
+	if rec.flag&samReverse != 0 { // Query coordinates in the original orientation of the read
+		qstart, qend = readLen-qend+1, readLen-qstart+1
+		sstart, send = send, sstart
+	}
+	ident, mismatch := 0.0, 0
+	if rec.hasNM && alnlen > 0 {
+		ident = 100 * float64(alnlen-rec.nm) / float64(alnlen)
+		if mismatch = rec.nm - indels; mismatch < 0 {
+			mismatch = 0
+		}
+	}
+	mapq := rec.mapq
+	if mapq == 255 { // Not available
+		mapq = 0
+	}
+	score := float64(mapq)
+	if SAMScore == "AS" && rec.hasAS {
+		score = float64(rec.as)
+		if rec.as <= 0 {
+			score = float64(alnlen + rec.as)
+		}
+	}
+	if score < 0 {
+		score = 0
+	}
+	return M8Line(buf, rec.qname, rec.rname, ident, alnlen, mismatch, gapopen, qstart, qend, sstart, send, 0, score, 0)
+}
+
+//samGrouper groups the alignments (primary, secondary and supplementary) of each read in m8 blocks.
+//The alignments of paired reads are tagged with /1 and /2 and the ones of the first mate are written first
+type samGrouper struct {
+	w            *bufio.Writer
+	qname        []byte
+	mate1, mate2 []byte
+}
+
+func (g *samGrouper) add(rec *samRecord) error {
+	if rec.flag&samUnmapped != 0 || len(rec.rname) == 0 || rec.rname[0] == '*' {
+		return nil
+	}
+	if !bytes.Equal(rec.qname, g.qname) {
+		if err := g.flush(); err != nil {
+			return err
+		}
+		g.qname = append(g.qname[:0], rec.qname...)
+	}
+	name := string(rec.qname)
+	out := &g.mate1
+	if rec.flag&samPaired != 0 {
+		switch {
+		case rec.flag&samMate1 != 0:
+			name += "/1"
+		case rec.flag&samMate2 != 0:
+			name += "/2"
+			out = &g.mate2
+		}
+	}
+	rec.qname = noTabs(name)
+	*out = rec.m8Line(*out)
+	return nil
+}
+
+func (g *samGrouper) flush() error {
+	for _, b := range []*[]byte{&g.mate1, &g.mate2} {
+		if _, err := g.w.Write(*b); err != nil {
+			return err
+		}
+		*b = (*b)[:0]
+	}
+	return nil
+}
+
+//isSAM tells if the file starts like a SAM file: a header or an alignment line with a valid flag and CIGAR
+func isSAM(head []byte) bool {
+	for _, h := range []string{"@HD\t", "@SQ\t", "@RG\t", "@PG\t", "@CO\t"} {
+		if bytes.HasPrefix(head, []byte(h)) {
+			return true
+		}
+	}
+	line := head
+	if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	fields := bytes.Split(line, []byte{'\t'})
+	if len(fields) < 11 {
+		return false
+	}
+	_, err := atoi(fields[1])
+	return err == nil && cigarRx.Match(fields[5])
+}
+
+//parseCigar parses a text CIGAR in ops
+func parseCigar(b []byte, ops []cigarOp) ([]cigarOp, error) {
+	ops = ops[:0]
+	if len(b) == 1 && b[0] == '*' {
+		return ops, nil
+	}
+	n := 0
+	for _, ch := range b {
+		if ch >= '0' && ch <= '9' {
+			n = n*10 + int(ch-'0')
+			continue
+		}
+		if bytes.IndexByte([]byte(cigarOps), ch) < 0 {
+			return ops, ErrBadNumber
+		}
+		ops = append(ops, cigarOp{op: ch, len: n})
+		n = 0
+	}
+	return ops, nil
+}
+
+//ConvertSAM converts a SAM file to m8 lines. Its alignments must be grouped by read name
+//(as written by the aligners, see SortFile otherwise). Malformed lines are skipped unless StrictConvert is set
+func ConvertSAM(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	g := &samGrouper{w: w}
+	var rec samRecord
+	var scratch []byte
+	fields := make([][]byte, 0, 16)
+	for lineno := 1; ; lineno++ {
+		line, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return g.flush()
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 || line[0] == '@' {
+			continue
+		}
+		fields = splitFields(line, fields)
+		if len(fields) < 11 {
+			if err := skipRecord(&ParseError{Line: lineno, Text: string(line), Err: ErrFieldCount}); err != nil {
+				return err
+			}
+			continue
+		}
+		var ferr [4]error
+		rec = samRecord{qname: fields[0], rname: fields[2], cigar: rec.cigar}
+		rec.flag, ferr[0] = atoi(fields[1])
+		rec.pos, ferr[1] = atoi(fields[3])
+		rec.mapq, ferr[2] = atoi(fields[4])
+		rec.cigar, ferr[3] = parseCigar(fields[5], rec.cigar)
+		var perr *ParseError
+		for i, name := range []string{"flag", "position", "mapping quality", "CIGAR"} {
+			if ferr[i] != nil && perr == nil {
+				perr = &ParseError{Line: lineno, Field: name, Text: string(line), Err: ErrBadNumber}
+			}
+		}
+		if perr != nil {
+			if err := skipRecord(perr); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, tag := range fields[11:] {
+			if len(tag) < 6 || tag[2] != ':' || tag[4] != ':' || tag[3] != 'i' {
+				continue
+			}
+			v, err := atoi(tag[5:])
+			if err != nil {
+				continue
+			}
+			switch string(tag[:2]) {
+			case "AS":
+				rec.as, rec.hasAS = v, true
+			case "NM":
+				rec.nm, rec.hasNM = v, true
+			}
+		}
+		if err := g.add(&rec); err != nil {
+			return err
+		}
+	}
+}
+
+//isBAM tells if the (decompressed) file starts like a BAM file
+func isBAM(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("BAM\x01"))
+}
+
+//bamTagSize returns the size of the value of a BAM tag of the given type (-1 for NUL terminated ones)
+func bamTagSize(typ byte) int {
+	switch typ {
+	case 'A', 'c', 'C':
+		return 1
+	case 's', 'S':
+		return 2
+	case 'i', 'I', 'f':
+		return 4
+	}
+	return -1
+}
+
+//bamInt reads an integer tag value of the given type
+func bamInt(typ byte, b []byte) (int, bool) {
+	switch typ {
+	case 'c':
+		return int(int8(b[0])), true
+	case 'C':
+		return int(b[0]), true
+	case 's':
+		return int(int16(binary.LittleEndian.Uint16(b))), true
+	case 'S':
+		return int(binary.LittleEndian.Uint16(b)), true
+	case 'i':
+		return int(int32(binary.LittleEndian.Uint32(b))), true
+	case 'I':
+		return int(binary.LittleEndian.Uint32(b)), true
+	}
+	return 0, false
+}
+
+//parseBAMTags reads the AS and NM tags of a BAM record
+func (rec *samRecord) parseBAMTags(b []byte) error {
+	for len(b) >= 3 {
+		tag, typ := b[:2], b[2]
+		b = b[3:]
+		size := bamTagSize(typ)
+		switch {
+		case typ == 'Z' || typ == 'H':
+			if size = bytes.IndexByte(b, 0) + 1; size == 0 {
+				return errors.New("Truncated BAM tag")
+			}
+		case typ == 'B':
+			if len(b) < 5 {
+				return errors.New("Truncated BAM tag")
+			}
+			elem := bamTagSize(b[0])
+			if elem < 0 {
+				return fmt.Errorf("Unknown BAM array type %c", b[0])
+			}
+			size = 5 + elem*int(binary.LittleEndian.Uint32(b[1:5]))
+		case size < 0:
+			return fmt.Errorf("Unknown BAM tag type %c", typ)
+		}
+		if size > len(b) || size < 0 {
+			return errors.New("Truncated BAM tag")
+		}
+		if v, ok := bamInt(typ, b); ok {
+			switch string(tag) {
+			case "AS":
+				rec.as, rec.hasAS = v, true
+			case "NM":
+				rec.nm, rec.hasNM = v, true
+			}
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+//ConvertBAM converts a BAM file to m8 lines. BAM files are BGZF (gzip) compressed, so r must be
+//the decompressed stream (see xopen.Open). Its alignments must be grouped by read name
+//(as written by the aligners, see SortFile otherwise). Malformed records are skipped unless StrictConvert is set,
+//truncated files are always an error
+func ConvertBAM(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	var hdr [4]byte
+	readInt := func() (int, error) {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, err
+		}
+		return int(int32(binary.LittleEndian.Uint32(hdr[:]))), nil
+	}
+	truncated := func(err error) error {
+		return fmt.Errorf("Truncated BAM file: %s", err)
+	}
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || !isBAM(hdr[:]) {
+		return errors.New("Not a BAM file")
+	}
+	ltext, err := readInt()
+	if err == nil {
+		_, err = r.Discard(ltext)
+	}
+	if err != nil {
+		return truncated(err)
+	}
+	nref, err := readInt()
+	if err != nil {
+		return truncated(err)
+	}
+	refs := make([][]byte, nref)
+	for i := range refs {
+		lname, err := readInt()
+		if err != nil {
+			return truncated(err)
+		}
+		name := make([]byte, lname)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return truncated(err)
+		}
+		refs[i] = firstWord(bytes.TrimRight(name, "\x00"))
+		if _, err := readInt(); err != nil { // Reference length
+			return truncated(err)
+		}
+	}
+
+	g := &samGrouper{w: w}
+	var rec samRecord
+	var buf []byte
+	for nrec := 1; ; nrec++ {
+		size, err := readInt()
+		if err == io.EOF {
+			return g.flush()
+		}
+		if err != nil {
+			return truncated(err)
+		}
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(r, buf); err != nil || size < 32 {
+			return truncated(err)
+		}
+		le := binary.LittleEndian
+		refID := int(int32(le.Uint32(buf[0:])))
+		lname := int(buf[8])
+		ncigar := int(le.Uint16(buf[12:]))
+		lseq := int(int32(le.Uint32(buf[16:])))
+		rec = samRecord{
+			pos:   int(int32(le.Uint32(buf[4:]))) + 1,
+			mapq:  int(buf[9]),
+			flag:  int(le.Uint16(buf[14:])),
+			cigar: rec.cigar[:0],
+		}
+		off := 32
+		end := off + lname + 4*ncigar + (lseq+1)/2 + lseq
+		if end > size || lname < 1 {
+			if err := skipRecord(&ParseError{Record: nrec, Err: ErrBadRecord}); err != nil {
+				return err
+			}
+			continue
+		}
+		rec.qname = buf[off : off+lname-1]
+		off += lname
+		var perr *ParseError
+		for i := 0; i < ncigar; i++ {
+			c := le.Uint32(buf[off:])
+			if int(c&0xf) >= len(cigarOps) {
+				perr = &ParseError{Record: nrec, Field: "CIGAR", Text: string(rec.qname), Err: ErrBadRecord}
+				break
+			}
+			rec.cigar = append(rec.cigar, cigarOp{op: cigarOps[c&0xf], len: int(c >> 4)})
+			off += 4
+		}
+		if refID >= 0 && refID < len(refs) {
+			rec.rname = refs[refID]
+		}
+		if perr == nil {
+			if err := rec.parseBAMTags(buf[end:]); err != nil {
+				perr = &ParseError{Record: nrec, Field: "tags", Text: string(rec.qname), Err: err}
+			}
+		}
+		if perr != nil {
+			if err := skipRecord(perr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := g.add(&rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+//convert runs a converter on input and returns its output
+func convert(conv Converter, input []byte) (string, error) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err := conv(bufio.NewReader(bytes.NewReader(input)), bytes.NewReader(input), w)
+	w.Flush()
+	return out.String(), err
+}
+
+//samLine returns a SAM alignment line without sequence and qualities
+func samLine(qname string, flag string, rname, pos, mapq, cigar string, tags ...string) string {
+	fields := append([]string{qname, flag, rname, pos, mapq, cigar, "*", "0", "0", "*", "*"}, tags...)
+	return strings.Join(fields, "\t") + "\n"
+}
+
+var samTests = []struct {
+	name  string
+	input string
+	score string
+	want  string
+}{
+	{
+		name:  "clipped alignment with insertion",
+		input: "@HD\tVN:1.6\n" + samLine("r1", "0", "gi|11|ref|X|", "100", "60", "5S20M2I10M", "NM:i:4", "AS:i:50"),
+		want:  "r1\tgi|11|ref|X|\t87.50\t32\t2\t1\t6\t37\t100\t129\t0\t50\n",
+	},
+	{
+		name:  "reverse strand",
+		input: samLine("r1", "16", "gi|11|ref|X|", "100", "60", "5S20M2I10M", "NM:i:4", "AS:i:50"),
+		want:  "r1\tgi|11|ref|X|\t87.50\t32\t2\t1\t1\t32\t129\t100\t0\t50\n",
+	},
+	{
+		name: "paired end-to-end scores",
+		input: samLine("r2", "129", "gi|22|", "1", "60", "10M", "AS:i:0") +
+			samLine("r2", "65", "gi|22|", "5", "60", "10M", "AS:i:-3"),
+		want: "r2/1\tgi|22|\t0.00\t10\t0\t0\t1\t10\t5\t14\t0\t7\n" +
+			"r2/2\tgi|22|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t10\n",
+	},
+	{
+		name: "mapping quality",
+		input: samLine("r1", "0", "gi|11|", "1", "42", "10M", "AS:i:50") +
+			samLine("r1", "256", "gi|12|", "1", "255", "10M", "AS:i:50"),
+		score: "MAPQ",
+		want: "r1\tgi|11|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t42\n" +
+			"r1\tgi|12|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t0\n",
+	},
+	{
+		name:  "unmapped read",
+		input: samLine("r3", "4", "*", "0", "0", "*"),
+	},
+}
+
+func TestConvertSAM(t *testing.T) {
+	for _, tt := range samTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.score != "" {
+				defer func(score string) { SAMScore = score }(SAMScore)
+				SAMScore = tt.score
+			}
+			got, err := convert(ConvertSAM, []byte(tt.input))
+			if err != nil {
+				t.Fatalf("ConvertSAM() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ConvertSAM() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertSAMMalformed(t *testing.T) {
+	input := samLine("r1", "X", "gi|11|", "1", "60", "10M") + "r2\t0\tgi|11|\n" +
+		samLine("r3", "0", "gi|11|", "1", "60", "10M", "AS:i:20")
+	before := ConvertSkipped()
+	got, err := convert(ConvertSAM, []byte(input))
+	if err != nil {
+		t.Fatalf("ConvertSAM() error = %v", err)
+	}
+	if want := "r3\tgi|11|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t20\n"; got != want {
+		t.Errorf("ConvertSAM() = %q, want %q", got, want)
+	}
+	if skipped := ConvertSkipped() - before; skipped != 2 {
+		t.Errorf("ConvertSAM() skipped %d lines, want 2", skipped)
+	}
+
+	StrictConvert = true
+	defer func() { StrictConvert = false }()
+	_, err = convert(ConvertSAM, []byte(input))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 1 || perr.Field != "flag" {
+		t.Errorf("ConvertSAM() with StrictConvert error = %v, want the flag of line 1", err)
+	}
+}
+
+//bamRecord is an alignment of the BAM test files
+type bamRecord struct {
+	qname      string
+	flag       uint16
+	ref, pos   int32 // 0-based position
+	mapq       uint8
+	cigar      []uint32 // Length << 4 | operation
+	lseq       int32
+	tags       []byte
+	badNameLen bool
+}
+
+//bamFile builds an uncompressed BAM stream with the given references and records
+func bamFile(refs []string, records []bamRecord) []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("BAM\x01")
+	text := "@HD\tVN:1.6\n"
+	binary.Write(&b, le, int32(len(text)))
+	b.WriteString(text)
+	binary.Write(&b, le, int32(len(refs)))
+	for _, ref := range refs {
+		binary.Write(&b, le, int32(len(ref)+1))
+		b.WriteString(ref)
+		b.WriteByte(0)
+		binary.Write(&b, le, int32(1000))
+	}
+	for _, r := range records {
+		var rec bytes.Buffer
+		lname := uint8(len(r.qname) + 1)
+		if r.badNameLen {
+			lname = 0
+		}
+		binary.Write(&rec, le, []int32{r.ref, r.pos})
+		rec.WriteByte(lname)
+		rec.WriteByte(r.mapq)
+		binary.Write(&rec, le, []uint16{0, uint16(len(r.cigar)), r.flag})
+		binary.Write(&rec, le, []int32{r.lseq, -1, -1, 0})
+		rec.WriteString(r.qname)
+		rec.WriteByte(0)
+		binary.Write(&rec, le, r.cigar)
+		rec.Write(make([]byte, (r.lseq+1)/2+r.lseq))
+		rec.Write(r.tags)
+		binary.Write(&b, le, int32(rec.Len()))
+		b.Write(rec.Bytes())
+	}
+	return b.Bytes()
+}
+
+//bamCigar encodes a CIGAR operation
+func bamCigar(n int, op byte) uint32 {
+	return uint32(n)<<4 | uint32(strings.IndexByte(cigarOps, op))
+}
+
+func TestConvertBAM(t *testing.T) {
+	refs := []string{"gi|11|ref|X| description", "gi|22|"}
+	cigar := []uint32{bamCigar(5, 'S'), bamCigar(20, 'M'), bamCigar(2, 'I'), bamCigar(10, 'M')}
+	tags := []byte("XAZtext\x00NMC\x04ASs\x32\x00BCZx\x00")
+	tests := []struct {
+		name    string
+		records []bamRecord
+		want    string
+		skipped int64
+	}{
+		{
+			name: "tags of every size",
+			records: []bamRecord{
+				{qname: "r1", ref: 0, pos: 99, mapq: 60, cigar: cigar, lseq: 37, tags: tags},
+				{qname: "r1", flag: 256 | 16, ref: 1, pos: 99, mapq: 0, cigar: cigar, lseq: 0,
+					tags: []byte("ASi\xfd\xff\xff\xffZBBC\x02\x00\x00\x00\x07\x08")},
+			},
+			want: "r1\tgi|11|ref|X|\t87.50\t32\t2\t1\t6\t37\t100\t129\t0\t50\n" +
+				"r1\tgi|22|\t0.00\t32\t0\t1\t1\t32\t129\t100\t0\t29\n",
+		},
+		{
+			name: "paired and unmapped",
+			records: []bamRecord{
+				{qname: "r2", flag: 1 | 128, ref: 1, pos: 0, mapq: 60, cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASC\x0a")},
+				{qname: "r2", flag: 1 | 64, ref: 1, pos: 4, mapq: 60, cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASC\x07")},
+				{qname: "r3", flag: 4, ref: -1, pos: -1},
+			},
+			want: "r2/1\tgi|22|\t0.00\t10\t0\t0\t1\t10\t5\t14\t0\t7\n" +
+				"r2/2\tgi|22|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t10\n",
+		},
+		{
+			name: "malformed records",
+			records: []bamRecord{
+				{qname: "r1", ref: 0, pos: 0, mapq: 60, cigar: []uint32{10<<4 | 12}},
+				{qname: "r2", ref: 0, pos: 0, mapq: 60, cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASq\x01")},
+				{qname: "r3", ref: 0, pos: 0, mapq: 60, badNameLen: true},
+				{qname: "r3", ref: 0, pos: 0, mapq: 60, cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ZBB\x01\x02\x00\x00\x00\x07\x08")},
+				{qname: "r4", ref: 0, pos: 0, mapq: 60, cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASC\x14")},
+			},
+			want:    "r4\tgi|11|ref|X|\t0.00\t10\t0\t0\t1\t10\t1\t10\t0\t20\n",
+			skipped: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ConvertSkipped()
+			got, err := convert(ConvertBAM, bamFile(refs, tt.records))
+			if err != nil {
+				t.Fatalf("ConvertBAM() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ConvertBAM() = %q, want %q", got, tt.want)
+			}
+			if skipped := ConvertSkipped() - before; skipped != tt.skipped {
+				t.Errorf("ConvertBAM() skipped %d records, want %d", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestConvertBAMErrors(t *testing.T) {
+	good := bamFile([]string{"gi|11|"}, []bamRecord{{qname: "r1", cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASC\x14")}})
+	bad := bamFile([]string{"gi|11|"}, []bamRecord{{qname: "r1", cigar: []uint32{bamCigar(10, 'M')}, tags: []byte("ASq\x14")}})
+	tests := []struct {
+		name   string
+		input  []byte
+		strict bool
+		want   string
+	}{
+		{"not a BAM file", []byte("@HD\tVN:1.6\n"), false, "Not a BAM file"},
+		{"truncated header", good[:10], false, "Truncated BAM file"},
+		{"truncated record", good[:len(good)-3], false, "Truncated BAM file"},
+		{"strict", bad, true, "record 1: tags: Unknown BAM tag type q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			StrictConvert = tt.strict
+			defer func() { StrictConvert = false }()
+			if _, err := convert(ConvertBAM, tt.input); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ConvertBAM() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+//readLine returns the next line of r without the line terminator ("\n" or "\r\n").
+//The line is a slice of r's buffer (valid until the next read) unless it doesn't fit in the buffer,
+//in which case it is collected in scratch
+func readLine(r *bufio.Reader, scratch *[]byte) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		*scratch = append((*scratch)[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.ReadSlice('\n')
+			*scratch = append(*scratch, line...)
+		}
+		line = *scratch
+	}
+	if err == io.EOF && len(line) > 0 { // Last line without line terminator
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l := len(line); l > 0 && line[l-1] == '\n' {
+		line = line[:l-1]
+	}
+	if l := len(line); l > 0 && line[l-1] == '\r' {
+		line = line[:l-1]
+	}
+	return line, nil
+}
+
+//splitFields splits the line by tabs reusing the fields slice
+func splitFields(line []byte, fields [][]byte) [][]byte {
+	fields = fields[:0]
+	for {
+		tab := bytes.IndexByte(line, '\t')
+		if tab < 0 {
+			return append(fields, line)
+		}
+		fields = append(fields, line[:tab])
+		line = line[tab+1:]
+	}
+}
+
+//atoi converts a decimal number (surrounded by optional spaces) to int without allocations
+func atoi(b []byte) (int, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return 0, errors.New("empty number")
+	}
+	neg := false
+	if b[0] == '-' || b[0] == '+' {
+		neg = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, errors.New("invalid number")
+	}
+	n := 0
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			return 0, errors.New("invalid digit in number")
+		}
+		n = n*10 + int(ch-'0')
+	}
+	if neg {
+		return -n, nil
+	}
+	return n, nil
+}
+
+//atof converts a float (surrounded by optional spaces) to float64.
+//The string conversion of short fields doesn't escape, so it doesn't allocate
+func atof(b []byte) (float64, error) {
+	return strconv.ParseFloat(string(bytes.TrimSpace(b)), 64)
+}
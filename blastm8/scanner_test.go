@@ -0,0 +1,160 @@
+package blastm8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"lines", "a\tb\nc\n", []string{"a\tb", "c"}},
+		{"crlf", "a\r\nb\r\n", []string{"a", "b"}},
+		{"no final newline", "a\nb", []string{"a", "b"}},
+		{"no final newline after crlf", "a\r\nb\r", []string{"a", "b"}},
+		{"empty lines", "\n\r\na\n", []string{"", "", "a"}},
+		{"lines longer than the buffer", long + "\n" + long + "y\r\nz\n", []string{long, long + "y", "z"}},
+		{"last line longer than the buffer", "a\n" + long, []string{"a", long}},
+		{"empty input", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReaderSize(strings.NewReader(tt.input), 16) // The smallest buffer
+			var scratch []byte
+			var got []string
+			for {
+				line, err := readLine(r, &scratch)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("readLine() error = %v", err)
+				}
+				got = append(got, string(line))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	fields := make([][]byte, 0, 2)
+	for input, want := range map[string][]string{
+		"a\tb\tc":  {"a", "b", "c"},
+		"a":        {"a"},
+		"":         {""},
+		"a\t\tb\t": {"a", "", "b", ""},
+	} {
+		fields = splitFields([]byte(input), fields)
+		var got []string
+		for _, f := range fields {
+			got = append(got, string(f))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("splitFields(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestAtoi(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"42", 42, true},
+		{" 42 ", 42, true},
+		{"+7", 7, true},
+		{"-7", -7, true},
+		{"007", 7, true},
+		{"999999999999999999", 999999999999999999, true}, // 18 digits, the longest accepted
+		{"1000000000000000000", 0, false},
+		{"", 0, false},
+		{"  ", 0, false},
+		{"-", 0, false},
+		{"+-1", 0, false},
+		{"1.5", 0, false},
+		{"1e3", 0, false},
+		{"12a", 0, false},
+		{"N/A", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := atoi([]byte(tt.input))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("atoi(%q) = %d, %v, want %d (valid: %v)", tt.input, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAtof(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+		ok    bool
+	}{
+		{"99.5", 99.5, true},
+		{" 1e-20 ", 1e-20, true},
+		{"0.0", 0, true},
+		{"-3", -3, true},
+		{"1E+300", 1e300, true},
+		{"inf", math.Inf(1), true},
+		{"", 0, false},
+		{"1,5", 0, false},
+		{"x", 0, false},
+		{"1e400", math.Inf(1), false}, // Out of range
+	}
+	for _, tt := range tests {
+		got, err := atof([]byte(tt.input))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("atof(%q) = %v, %v, want %v (valid: %v)", tt.input, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAtoiAtofAllocs(t *testing.T) {
+	i, f := []byte(" 12345 "), []byte("1.5e-20")
+	if allocs := testing.AllocsPerRun(100, func() { atoi(i) }); allocs != 0 {
+		t.Errorf("atoi() allocates %v times", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { atof(f) }); allocs != 0 {
+		t.Errorf("atof() allocates %v times", allocs)
+	}
+}
+
+func BenchmarkProcfile(b *testing.B) {
+	var input strings.Builder
+	for q := 0; q < 2000; q++ {
+		for h := 0; h < 10; h++ {
+			fmt.Fprintf(&input, "query%d\tgi|%d|ref|NP_%d.1|\t98.50\t200\t3\t0\t1\t200\t5\t204\t1e-50\t%d\n", q, q*10+h, h, 400-h)
+		}
+	}
+	data := input.String()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out := make(chan *BlastBlock, 64)
+		done := make(chan struct{})
+		go func() {
+			for range out {
+			}
+			close(done)
+		}()
+		if _, err := Procfile(bufio.NewReader(strings.NewReader(data)), out, false, nil); err != nil {
+			b.Fatalf("Procfile() error = %v", err)
+		}
+		<-done
+	}
+}
@@ -0,0 +1,186 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//Columns of an m8 line
+const (
+	colQuery = iota
+	colSubject
+	colIdent
+	colAlnLen
+	colMismatch
+	colGapOpen
+	colQStart
+	colQEnd
+	colSStart
+	colSEnd
+	colEvalue
+	colBitsc
+	colTaxid
+	nCols
+)
+
+//tabField is the m8 column of a named field of a tabular format. Identities given as fractions have scale 100
+type tabField struct {
+	col   int
+	scale float64
+}
+
+//mmseqsFields are the field names of MMseqs2 convertalis (--format-output) that are used
+var mmseqsFields = map[string]tabField{
+	"query":    {colQuery, 1},
+	"target":   {colSubject, 1},
+	"fident":   {colIdent, 100},
+	"pident":   {colIdent, 1},
+	"alnlen":   {colAlnLen, 1},
+	"mismatch": {colMismatch, 1},
+	"gapopen":  {colGapOpen, 1},
+	"qstart":   {colQStart, 1},
+	"qend":     {colQEnd, 1},
+	"tstart":   {colSStart, 1},
+	"tend":     {colSEnd, 1},
+	"evalue":   {colEvalue, 1},
+	"bits":     {colBitsc, 1},
+	"taxid":    {colTaxid, 1},
+}
+
+//MMseqsFields are the columns of the MMseqs2 convertalis files without a header line (its --format-output,
+//comma separated). Defaults to the convertalis default. Files written with --format-mode 4 have their own header line
+var MMseqsFields = "query,target,fident,alnlen,mismatch,gapopen,qstart,qend,tstart,tend,evalue,bits"
+
+//tabColumns maps the columns of a tabular file to the m8 columns
+type tabColumns struct {
+	index [nCols]int // Column of the file of each m8 column, -1 if it doesn't have it
+	scale [nCols]float64
+	min   int // Number of columns needed
+}
+
+//newTabColumns maps the fields (names of the columns of the file) with the known names.
+//The query, the subject and the bit score are needed
+func newTabColumns(fields []string, names map[string]tabField) (*tabColumns, error) {
+	c := &tabColumns{}
+	for i := range c.index {
+		c.index[i] = -1
+	}
+	for i, name := range fields {
+		f, ok := names[strings.TrimSpace(name)]
+		if !ok || c.index[f.col] >= 0 {
+			continue
+		}
+		c.index[f.col] = i
+		c.scale[f.col] = f.scale
+		if i >= c.min {
+			c.min = i + 1
+		}
+	}
+	for _, col := range []int{colQuery, colSubject, colBitsc} {
+		if c.index[col] < 0 {
+			return nil, fmt.Errorf("No query, subject or bit score in the fields %s", strings.Join(fields, ","))
+		}
+	}
+	return c, nil
+}
+
+//m8Line appends the fields of a line of the file as an m8 line to buf. Missing columns are 0
+func (c *tabColumns) m8Line(buf []byte, fields [][]byte) ([]byte, error) {
+	if len(fields) < c.min {
+		return buf, ErrFieldCount
+	}
+	for col, i := range c.index {
+		if col == colTaxid {
+			if i >= 0 {
+				buf = append(buf, '\t')
+				buf = append(buf, fields[i]...)
+			}
+			break
+		}
+		if col > 0 {
+			buf = append(buf, '\t')
+		}
+		switch {
+		case i < 0:
+			buf = append(buf, '0')
+		case c.scale[col] != 1:
+			v, err := atof(fields[i])
+			if err != nil {
+				return buf, ErrBadNumber
+			}
+			buf = strconv.AppendFloat(buf, v*c.scale[col], 'f', 2, 64)
+		default:
+			buf = append(buf, fields[i]...)
+		}
+	}
+	return append(buf, '\n'), nil
+}
+
+//convertTabular converts the lines of a tabular file with the given columns to m8 lines.
+//Lines starting with # are skipped, and so are the malformed ones unless StrictConvert is set
+func convertTabular(r *bufio.Reader, w *bufio.Writer, cols *tabColumns, lineno int) error {
+	var scratch, line []byte
+	fields := make([][]byte, 0, 16)
+	for ; ; lineno++ {
+		text, err := readLine(r, &scratch)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		fields = splitFields(text, fields)
+		line, err = cols.m8Line(line[:0], fields)
+		if err != nil {
+			if err := skipRecord(&ParseError{Line: lineno, Text: string(text), Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+//isMMseqs tells if the file starts with the header line of MMseqs2 convertalis --format-mode 4
+func isMMseqs(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("query\ttarget\t"))
+}
+
+//ConvertMMseqs converts MMseqs2 convertalis output to m8 lines. The columns are taken from its header line
+//(--format-mode 4) or MMseqsFields. Identities given as fractions (fident) are converted to percentages
+func ConvertMMseqs(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	fields := strings.Split(MMseqsFields, ",")
+	lineno := 1
+	if isMMseqs(peekLine(r)) {
+		var scratch []byte
+		header, err := readLine(r, &scratch)
+		if err != nil {
+			return err
+		}
+		fields = strings.Split(string(header), "\t")
+		lineno++
+	}
+	cols, err := newTabColumns(fields, mmseqsFields)
+	if err != nil {
+		return err
+	}
+	return convertTabular(r, w, cols, lineno)
+}
+
+//peekLine returns the first line of r (up to the size of its buffer) without consuming it
+func peekLine(r *bufio.Reader) []byte {
+	head, _ := r.Peek(detectSize)
+	if nl := bytes.IndexByte(head, '\n'); nl >= 0 {
+		head = head[:nl+1]
+	}
+	return head
+}
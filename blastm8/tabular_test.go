@@ -0,0 +1,90 @@
+package blastm8
+
+import (
+	"errors"
+	"testing"
+)
+
+//converterTests have a malformed line (or alignment) between two good ones for each converter
+var converterTests = []struct {
+	name    string
+	conv    Converter
+	input   string
+	want    string
+	badLine int
+}{
+	{
+		name: "PAF",
+		conv: ConvertPAF,
+		input: "r1\t1000\t10\t110\t-\tgi|10|\t5000\t100\t200\t98\t100\t60\ttp:A:P\tAS:i:266\n" +
+			"r2\t1000\tx\t110\t+\tgi|10|\t5000\t100\t200\t98\t100\t60\n" +
+			"r3\t1000\t0\t50\t+\tgi|11|\t5000\t0\t50\t50\t50\t60\n",
+		want: "r1\tgi|10|\t98.00\t100\t2\t0\t11\t110\t200\t101\t0\t266\n" +
+			"r3\tgi|11|\t100.00\t50\t0\t0\t1\t50\t1\t50\t0\t50\n",
+		badLine: 2,
+	},
+	{
+		name: "LAST TAB",
+		conv: ConvertLastTab,
+		input: "# LAST version 1234\n" +
+			"266\tgi|10|\t100\t100\t+\t5000\tr1\t5\t100\t-\t1000\t60,2:0,40\tE=1e-05\n" +
+			"x\tgi|10|\t100\t100\t+\t5000\tr2\t5\t100\t+\t1000\t100\n" +
+			"50\tgi|11|\t0\t20\t+\t5000\tr3\t0\t20\t+\t1000\t20\n",
+		want: "r1\tgi|10|\t0.00\t102\t0\t1\t896\t995\t200\t101\t1e-05\t266\n" +
+			"r3\tgi|11|\t0.00\t20\t0\t0\t1\t20\t1\t20\t0\t50\n",
+		badLine: 3,
+	},
+	{
+		name: "MAF",
+		conv: ConvertMAF,
+		input: "##maf version=1\n" +
+			"a score=20 E=1e-3\ns gi|1| 0 8 + 100 ACGT-CGT\ns q1 2 8 + 50 ACTTACGT\n\n" +
+			"a score=30\ns gi|2| 0 8 ? 100 ACGTACGT\ns q2 0 8 + 50 ACGTACGT\n\n" +
+			"a score=40\ns gi|3| 0 4 + 100 ACGT\ns q3 0 4 - 50 ACGA\n",
+		want: "q1\tgi|1|\t75.00\t8\t1\t1\t3\t10\t1\t8\t0.001\t20\n" +
+			"q3\tgi|3|\t75.00\t4\t1\t0\t47\t50\t4\t1\t0\t40\n",
+		badLine: 7,
+	},
+	{
+		name: "MMseqs2",
+		conv: ConvertMMseqs,
+		input: "r1\tgi|10|\t0.777\t100\t1\t0\t1\t100\t1\t100\t1e-10\t266\n" +
+			"r2\tgi|10|\t0.x\t100\t1\t0\t1\t100\t1\t100\t1e-10\t266\n" +
+			"r3\tgi|11|\t1.000\t50\t0\t0\t1\t50\t1\t50\t1e-5\t80\n",
+		want: "r1\tgi|10|\t77.70\t100\t1\t0\t1\t100\t1\t100\t1e-10\t266\n" +
+			"r3\tgi|11|\t100.00\t50\t0\t0\t1\t50\t1\t50\t1e-5\t80\n",
+		badLine: 2,
+	},
+}
+
+func TestConvertersSkipMalformed(t *testing.T) {
+	for _, tt := range converterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ConvertSkipped()
+			got, err := convert(tt.conv, []byte(tt.input))
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if skipped := ConvertSkipped() - before; skipped != 1 {
+				t.Errorf("skipped %d lines, want 1", skipped)
+			}
+		})
+	}
+}
+
+func TestConvertersStrict(t *testing.T) {
+	StrictConvert = true
+	defer func() { StrictConvert = false }()
+	for _, tt := range converterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := convert(tt.conv, []byte(tt.input))
+			var perr *ParseError
+			if !errors.As(err, &perr) || perr.Line != tt.badLine {
+				t.Errorf("error = %v, want a malformed line %d", err, tt.badLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+//DefaultSortMemory is the default number of bytes of blast lines kept in memory by SortFile before spilling them to disk
+const DefaultSortMemory = 256 * 1024 * 1024
+
+//lineRec is a line of a sort chunk
+type lineRec struct {
+	off, qlen, llen int
+}
+
+//sortChunk holds the lines of the input in memory until they are sorted and spilled to disk
+type sortChunk struct {
+	data  []byte
+	lines []lineRec
+}
+
+func (c *sortChunk) add(line []byte, qlen int) {
+	c.lines = append(c.lines, lineRec{off: len(c.data), qlen: qlen, llen: len(line)})
+	c.data = append(c.data, line...)
+}
+
+func (c *sortChunk) size() int {
+	return len(c.data) + len(c.lines)*24
+}
+
+func (c *sortChunk) line(i int) []byte {
+	l := c.lines[i]
+	return c.data[l.off : l.off+l.llen]
+}
+
+func (c *sortChunk) query(i int) []byte {
+	l := c.lines[i]
+	return c.data[l.off : l.off+l.qlen]
+}
+
+//sort sorts the lines by query keeping the input order of the lines of the same query
+func (c *sortChunk) sort() {
+	sort.SliceStable(c.lines, func(i, j int) bool {
+		return bytes.Compare(c.query(i), c.query(j)) < 0
+	})
+}
+
+//spill writes the sorted lines to a new temporary file in tmpDir and empties the chunk
+func (c *sortChunk) spill(tmpDir string) (string, error) {
+	c.sort()
+	fh, err := os.CreateTemp(tmpDir, "blast2lca-*.run")
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	w := bufio.NewWriterSize(fh, 1024*1024)
+	for i := range c.lines {
+		w.Write(c.line(i))
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return fh.Name(), err
+	}
+	c.data, c.lines = c.data[:0], c.lines[:0]
+	return fh.Name(), fh.Close()
+}
+
+//blockBuilder groups consecutive lines of the same query in blocks
+type blockBuilder struct {
+	query []byte // nil before the first line of a block
+	block []byte
+	out   chan<- *BlastBlock
+	quit  <-chan struct{}
+}
+
+//add adds a line to the block of its query. The lines of the queries without hits (the query and a tab, see
+//noHitsLine) only start the block. Returns false if quit is closed
+func (b *blockBuilder) add(query, line []byte) bool {
+	if b.query != nil && !bytes.Equal(query, b.query) && !b.flush() {
+		return false
+	}
+	if b.query == nil {
+		b.query = append(make([]byte, 0, len(query)), query...)
+	}
+	if len(line) > len(query)+1 {
+		b.block = append(b.block, line...)
+		b.block = append(b.block, '\n')
+	}
+	return true
+}
+
+//flush passes the current block to out. Returns false if quit is closed
+func (b *blockBuilder) flush() bool {
+	if b.query == nil {
+		return true
+	}
+	if !send(b.out, &BlastBlock{header: Header(b.query), block: b.block}, b.quit) {
+		return false
+	}
+	b.block = make([]byte, 0, cap(b.block))
+	b.query = nil
+	return true
+}
+
+//noHitsLine returns the line that stands for a query without hits in the sorted runs
+func noHitsLine(query []byte) []byte {
+	return append(query, '\t')
+}
+
+//ignoreEOF returns nil for io.EOF and err otherwise
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//runHead is the next line of a sorted run in the merge
+type runHead struct {
+	query, line []byte
+	run         int
+	r           *bufio.Reader
+	scratch     []byte
+}
+
+type runHeap []*runHead
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].query, h[j].query); c != 0 {
+		return c < 0
+	}
+	return h[i].run < h[j].run // Keeps the input order of the lines of the same query
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+//next reads the next line of the run. Returns false at the end of the run
+func (rh *runHead) next() (bool, error) {
+	line, err := readLine(rh.r, &rh.scratch)
+	if err != nil {
+		return false, ignoreEOF(err)
+	}
+	rh.line = line
+	rh.query, _ = extractQuery(line) // Lines were checked before spilling
+	return true, nil
+}
+
+//merge merges the sorted runs grouping the lines of the same query in blocks. It stops without error if the
+//quit channel of b is closed
+func merge(runs []string, b *blockBuilder) error {
+	h := make(runHeap, 0, len(runs))
+	for i, run := range runs {
+		fh, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		rh := &runHead{run: i, r: bufio.NewReaderSize(fh, 64*1024)}
+		ok, err := rh.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, rh)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		rh := h[0]
+		if !b.add(rh.query, rh.line) {
+			return nil
+		}
+		ok, err := rh.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+//SortFile is the alternative to Procfile for blast files whose lines for a query are not contiguous
+//(for example, concatenated results of sharded searches). The lines are grouped by query with an external sort:
+//up to maxMem bytes of lines are sorted in memory and spilled to temporary files in tmpDir that are merged at the end.
+//The blocks are passed to queryChan sorted by query, and their malformed lines are reported without line numbers.
+//Comment lines are handled as in Procfile. The sort stops without error when quit is closed (it may be nil), the
+//temporary files are always removed. queryChan is closed at the end.
+//Returns the number of skipped lines and any error it may encounter in the process
+func SortFile(iblast *bufio.Reader, queryChan chan<- *BlastBlock, strict bool, tmpDir string, maxMem int, quit <-chan struct{}) (int, error) {
+	defer close(queryChan)
+	chunk := &sortChunk{}
+	runs := make([]string, 0)
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+	lineno, skipped := 0, 0
+	var scratch []byte
+	var tracker noHitsTracker
+	for {
+		line, err := readLine(iblast, &scratch)
+		if err != nil {
+			if err = ignoreEOF(err); err != nil {
+				return skipped, err
+			}
+			break
+		}
+		lineno++
+		if aborted(quit) {
+			return skipped, nil
+		}
+		if isComment(line) {
+			noHits := tracker.comment(line)
+			if noHits == nil {
+				continue
+			}
+			line = noHitsLine(noHits)
+		}
+		query, qerr := extractQuery(line)
+		if qerr != nil {
+			perr := &ParseError{Line: lineno, Text: string(line), Err: qerr}
+			if strict {
+				return skipped, perr
+			}
+			warn(perr)
+			skipped++
+			continue
+		}
+		chunk.add(line, len(query))
+		if chunk.size() >= maxMem {
+			run, err := chunk.spill(tmpDir)
+			if run != "" {
+				runs = append(runs, run)
+			}
+			if err != nil {
+				return skipped, err
+			}
+		}
+	}
+	b := &blockBuilder{block: make([]byte, 0, 4096), out: queryChan, quit: quit}
+	if len(runs) == 0 { // Everything fits in memory
+		chunk.sort()
+		for i := range chunk.lines {
+			if !b.add(chunk.query(i), chunk.line(i)) {
+				return skipped, nil
+			}
+		}
+		b.flush()
+		return skipped, nil
+	}
+	if len(chunk.lines) > 0 {
+		run, err := chunk.spill(tmpDir)
+		if run != "" {
+			runs = append(runs, run)
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+	chunk = nil
+	if err := merge(runs, b); err != nil {
+		return skipped, err
+	}
+	b.flush()
+	return skipped, nil
+}
+
+//repeatFilterBits is the size in bits of the Bloom filter of the queries seen by WarnRepeats (64 MiB).
+//Up to about 10 million queries less than 1 in 10000 is wrongly reported as repeated
+const repeatFilterBits = 1 << 29
+
+//repeatFilterHashes is the number of bits of the Bloom filter set for each query
+const repeatFilterHashes = 4
+
+//queryFilter is a Bloom filter of query names of fixed size
+type queryFilter []uint64
+
+func newQueryFilter(bits int) queryFilter {
+	return make(queryFilter, (bits+63)/64)
+}
+
+//add sets the bits of the query with the given hash and tells if they were all set
+//(the query was probably added before)
+func (f queryFilter) add(hash uint64) bool {
+	nbits := uint64(len(f)) * 64
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	seen := true
+	for i := uint64(0); i < repeatFilterHashes; i++ {
+		bit := (h1 + i*h2) % nbits
+		if mask := uint64(1) << (bit % 64); f[bit/64]&mask == 0 {
+			f[bit/64] |= mask
+			seen = false
+		}
+	}
+	return seen
+}
+
+//WarnRepeats passes the blocks from inChan to outChan warning about the queries found in more than one block,
+//which means that the input is not grouped by query (see SortFile). The queries seen are kept in a Bloom filter of
+//fixed size, so the memory doesn't grow with the input but a few queries of very large inputs may be reported by mistake.
+//It stops when quit is closed (it may be nil). outChan is closed at the end. Returns the number of repeated blocks
+func WarnRepeats(inChan <-chan *BlastBlock, outChan chan<- *BlastBlock, quit <-chan struct{}) int {
+	defer close(outChan)
+	seen := newQueryFilter(repeatFilterBits)
+	repeats := 0
+	h := fnv.New64a()
+	for b := range inChan {
+		h.Reset()
+		h.Write(b.header)
+		if seen.add(h.Sum64()) {
+			repeats++
+			if int64(repeats) <= MaxWarnings {
+				log.Printf("WARNING: Query %s found in more than one block (line %d), the input is not grouped by query\n", b.header, b.line)
+			}
+		}
+		if !send(outChan, b, quit) {
+			break
+		}
+	}
+	return repeats
+}
@@ -0,0 +1,170 @@
+package blastm8
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//m8 returns an m8 line of query and subject with the given bit score
+func m8(query, subject, bitsc string) string {
+	return query + "\t" + subject + "\t100.00\t100\t0\t0\t1\t100\t1\t100\t1e-20\t" + bitsc + "\n"
+}
+
+//collect reads the blocks of out until it is closed, as "header: lines"
+func collect(out <-chan *BlastBlock) []string {
+	var blocks []string
+	for b := range out {
+		blocks = append(blocks, string(b.header)+": "+string(b.block))
+	}
+	return blocks
+}
+
+func TestSortFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		maxMem int
+		want   []string
+		skip   int
+	}{
+		{
+			name:   "grouped",
+			input:  m8("q1", "gi|1|", "50") + m8("q1", "gi|2|", "40") + m8("q2", "gi|3|", "30"),
+			maxMem: DefaultSortMemory,
+			want:   []string{"q1: " + m8("q1", "gi|1|", "50") + m8("q1", "gi|2|", "40"), "q2: " + m8("q2", "gi|3|", "30")},
+		},
+		{
+			name:   "interleaved in memory",
+			input:  m8("q2", "gi|1|", "50") + m8("q1", "gi|2|", "40") + m8("q2", "gi|3|", "30") + m8("q1", "gi|4|", "20"),
+			maxMem: DefaultSortMemory,
+			want:   []string{"q1: " + m8("q1", "gi|2|", "40") + m8("q1", "gi|4|", "20"), "q2: " + m8("q2", "gi|1|", "50") + m8("q2", "gi|3|", "30")},
+		},
+		{
+			name:   "interleaved merging runs",
+			input:  m8("q2", "gi|1|", "50") + m8("q1", "gi|2|", "40") + m8("q2", "gi|3|", "30") + m8("q1", "gi|4|", "20"),
+			maxMem: 1, // Every line is spilled to its own run
+			want:   []string{"q1: " + m8("q1", "gi|2|", "40") + m8("q1", "gi|4|", "20"), "q2: " + m8("q2", "gi|1|", "50") + m8("q2", "gi|3|", "30")},
+		},
+		{
+			name:   "queries without hits",
+			input:  "# BLASTN 2.12.0+\n# Query: q0\n# 0 hits found\n" + m8("q1", "gi|1|", "50") + "# Query: q3 desc\n# 0 hits found\n",
+			maxMem: 1,
+			want:   []string{"q0: ", "q1: " + m8("q1", "gi|1|", "50"), "q3: "},
+		},
+		{
+			name:   "malformed lines",
+			input:  m8("q2", "gi|1|", "50") + "not tab separated\n" + m8("q1", "gi|2|", "40") + "\tblank query\n",
+			maxMem: 1,
+			want:   []string{"q1: " + m8("q1", "gi|2|", "40"), "q2: " + m8("q2", "gi|1|", "50")},
+			skip:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			out := make(chan *BlastBlock, 16)
+			var skipped int
+			var err error
+			done := make(chan struct{})
+			go func() {
+				skipped, err = SortFile(bufio.NewReader(strings.NewReader(tt.input)), out, false, tmpDir, tt.maxMem, nil)
+				close(done)
+			}()
+			got := collect(out)
+			<-done
+			if err != nil {
+				t.Fatalf("SortFile() error = %v", err)
+			}
+			if skipped != tt.skip {
+				t.Errorf("SortFile() skipped %d lines, want %d", skipped, tt.skip)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortFile() blocks = %q, want %q", got, tt.want)
+			}
+			if runs, _ := filepath.Glob(filepath.Join(tmpDir, "*")); len(runs) > 0 {
+				t.Errorf("SortFile() left the temporary files %v", runs)
+			}
+		})
+	}
+}
+
+func TestSortFileStrict(t *testing.T) {
+	tmpDir := t.TempDir()
+	input := m8("q2", "gi|1|", "50") + m8("q1", "gi|2|", "40") + "not tab separated\n"
+	out := make(chan *BlastBlock, 16)
+	_, err := SortFile(bufio.NewReader(strings.NewReader(input)), out, true, tmpDir, 1, nil)
+	if !errors.Is(err, ErrNotTabSeparated) {
+		t.Errorf("SortFile() error = %v, want %v", err, ErrNotTabSeparated)
+	}
+	if got := collect(out); len(got) > 0 {
+		t.Errorf("SortFile() passed %q before failing", got)
+	}
+	if runs, _ := os.ReadDir(tmpDir); len(runs) > 0 {
+		t.Errorf("SortFile() left %d temporary files", len(runs))
+	}
+}
+
+func TestSortFileQuit(t *testing.T) {
+	tmpDir := t.TempDir()
+	input := m8("q2", "gi|1|", "50") + m8("q1", "gi|2|", "40") + m8("q3", "gi|3|", "30")
+	out := make(chan *BlastBlock) // Nobody reads it
+	quit := make(chan struct{})
+	close(quit)
+	if _, err := SortFile(bufio.NewReader(strings.NewReader(input)), out, false, tmpDir, 1, quit); err != nil {
+		t.Errorf("SortFile() error = %v", err)
+	}
+	if runs, _ := os.ReadDir(tmpDir); len(runs) > 0 {
+		t.Errorf("SortFile() left %d temporary files", len(runs))
+	}
+}
+
+func TestWarnRepeats(t *testing.T) {
+	blocks := []string{"q1", "q2", "q1", "q3", "q2"}
+	in := make(chan *BlastBlock, len(blocks))
+	for _, q := range blocks {
+		in <- &BlastBlock{header: Header(q)}
+	}
+	close(in)
+	out := make(chan *BlastBlock, len(blocks))
+	if repeats := WarnRepeats(in, out, nil); repeats != 2 {
+		t.Errorf("WarnRepeats() = %d, want 2", repeats)
+	}
+	if got := collect(out); len(got) != len(blocks) {
+		t.Errorf("WarnRepeats() passed %d blocks, want %d", len(got), len(blocks))
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	hashes := make([]uint64, 100000)
+	h := fnv.New64a()
+	for i := range hashes {
+		h.Reset()
+		fmt.Fprintf(h, "read%d", i)
+		hashes[i] = h.Sum64()
+	}
+	f := newQueryFilter(repeatFilterBits)
+	for i, hash := range hashes {
+		if f.add(hash) {
+			t.Fatalf("Query %d of %d reported as repeated", i, len(hashes))
+		}
+	}
+	for i, hash := range hashes {
+		if !f.add(hash) {
+			t.Fatalf("Repeated query %d not reported", i)
+		}
+	}
+	small := newQueryFilter(64) // The size is fixed, a full filter reports every query
+	for _, hash := range hashes[:1000] {
+		small.add(hash)
+	}
+	if len(small) != 1 || !small.add(hashes[len(hashes)-1]) {
+		t.Errorf("A full filter of 64 bits didn't report a new query")
+	}
+}
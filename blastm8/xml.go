@@ -0,0 +1,132 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//xmlIteration is the result of a query in a BLAST XML (-outfmt 5) file
+type xmlIteration struct {
+	QueryID  string   `xml:"Iteration_query-ID"`
+	QueryDef string   `xml:"Iteration_query-def"`
+	Hits     []xmlHit `xml:"Iteration_hits>Hit"`
+}
+
+type xmlHit struct {
+	ID   string   `xml:"Hit_id"`
+	Def  string   `xml:"Hit_def"`
+	Hsps []xmlHsp `xml:"Hit_hsps>Hsp"`
+}
+
+type xmlHsp struct {
+	BitScore  float64 `xml:"Hsp_bit-score"`
+	Evalue    float64 `xml:"Hsp_evalue"`
+	QueryFrom int     `xml:"Hsp_query-from"`
+	QueryTo   int     `xml:"Hsp_query-to"`
+	HitFrom   int     `xml:"Hsp_hit-from"`
+	HitTo     int     `xml:"Hsp_hit-to"`
+	Identity  int     `xml:"Hsp_identity"`
+	Gaps      int     `xml:"Hsp_gaps"`
+	AlignLen  int     `xml:"Hsp_align-len"`
+}
+
+//isXML tells if the file starts like a BLAST XML file
+func isXML(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<BlastOutput"))
+}
+
+//xmlQuery returns the query name as in tabular output: the first word of the definition line
+func (it *xmlIteration) xmlQuery() string {
+	if q := firstWord([]byte(it.QueryDef)); len(q) > 0 && it.QueryDef != "No definition line" {
+		return string(q)
+	}
+	return it.QueryID
+}
+
+//xmlSubject returns the subject id as in tabular output. Ids of databases without parsed seqids
+//(gnl|BL_ORD_ID|n) are replaced by the first word of the definition line
+func (h *xmlHit) xmlSubject() string {
+	if strings.HasPrefix(h.ID, "gnl|BL_ORD_ID|") {
+		if s := firstWord([]byte(h.Def)); len(s) > 0 {
+			return string(s)
+		}
+	}
+	return h.ID
+}
+
+//xmlTaxidRx matches the taxid in the definition lines of UniProt (OX=9606) and UniRef (TaxID=9606) databases
+var xmlTaxidRx = regexp.MustCompile(`(?i)\b(?:OX|tax_?id)=(\d+)`)
+
+//xmlTaxid returns the taxid given in the definition line or in the id of the hit (0 if there is none)
+func (h *xmlHit) xmlTaxid() int {
+	for _, s := range []string{h.Def, h.ID} {
+		if m := xmlTaxidRx.FindStringSubmatch(s); m != nil {
+			if taxid, err := strconv.Atoi(m[1]); err == nil {
+				return taxid
+			}
+		}
+	}
+	return 0
+}
+
+//noTabs replaces the tabs of a field of an m8 line
+func noTabs(s string) []byte {
+	return []byte(strings.Replace(s, "\t", " ", -1))
+}
+
+//ConvertXML converts a BLAST XML (-outfmt 5) file to m8 lines.
+//The file is read as a stream, only a query is kept in memory. The queries without hits are kept (see ConvertOutfmt7).
+//The taxids found in the definition lines of the hits (see xmlTaxid) are written in the 13th column
+func ConvertXML(r *bufio.Reader, src io.ReaderAt, w *bufio.Writer) error {
+	dec := xml.NewDecoder(r)
+	var line []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Iteration" {
+			continue
+		}
+		var it xmlIteration
+		if err := dec.DecodeElement(&it, &start); err != nil {
+			return err
+		}
+		query := noTabs(it.xmlQuery())
+		if len(it.Hits) == 0 {
+			if _, err := w.Write(noHitsLines(line[:0], query)); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, hit := range it.Hits {
+			subject := noTabs(hit.xmlSubject())
+			taxid := hit.xmlTaxid()
+			for _, hsp := range hit.Hsps {
+				ident := 0.0
+				if hsp.AlignLen > 0 {
+					ident = 100 * float64(hsp.Identity) / float64(hsp.AlignLen)
+				}
+				mismatch := hsp.AlignLen - hsp.Identity - hsp.Gaps
+				if mismatch < 0 {
+					mismatch = 0
+				}
+				line = M8Line(line[:0], query, subject, ident, hsp.AlignLen, mismatch, 0,
+					hsp.QueryFrom, hsp.QueryTo, hsp.HitFrom, hsp.HitTo, hsp.Evalue, hsp.BitScore, taxid)
+				if _, err := w.Write(line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package blastm8
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//xmlIterations wraps the iterations in a BLAST XML file
+func xmlIterations(iterations ...string) string {
+	return `<?xml version="1.0"?>
+<!DOCTYPE BlastOutput PUBLIC "-//NCBI//NCBI BlastOutput/EN" "http://www.ncbi.nlm.nih.gov/dtd/NCBI_BlastOutput.dtd">
+<BlastOutput>
+  <BlastOutput_program>blastp</BlastOutput_program>
+  <BlastOutput_iterations>
+` + strings.Join(iterations, "") + `  </BlastOutput_iterations>
+</BlastOutput>
+`
+}
+
+//xmlHitElement returns a Hit element with an HSP
+func xmlHitElement(id, def string) string {
+	return `<Hit>
+  <Hit_id>` + id + `</Hit_id>
+  <Hit_def>` + def + `</Hit_def>
+  <Hit_hsps>
+    <Hsp>
+      <Hsp_bit-score>80.5</Hsp_bit-score>
+      <Hsp_evalue>1e-20</Hsp_evalue>
+      <Hsp_query-from>1</Hsp_query-from>
+      <Hsp_query-to>100</Hsp_query-to>
+      <Hsp_hit-from>11</Hsp_hit-from>
+      <Hsp_hit-to>110</Hsp_hit-to>
+      <Hsp_identity>90</Hsp_identity>
+      <Hsp_gaps>2</Hsp_gaps>
+      <Hsp_align-len>100</Hsp_align-len>
+    </Hsp>
+  </Hit_hsps>
+</Hit>
+`
+}
+
+func xmlIterationElement(queryID, queryDef string, hits ...string) string {
+	return `<Iteration>
+  <Iteration_query-ID>` + queryID + `</Iteration_query-ID>
+  <Iteration_query-def>` + queryDef + `</Iteration_query-def>
+  <Iteration_hits>
+` + strings.Join(hits, "") + `  </Iteration_hits>
+</Iteration>
+`
+}
+
+func TestConvertXML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "gi subject",
+			input: xmlIterations(xmlIterationElement("Query_1", "read1 some description",
+				xmlHitElement("gi|123|ref|NP_1.1|", "a protein [Homo sapiens]"))),
+			want: "read1\tgi|123|ref|NP_1.1|\t90.00\t100\t8\t0\t1\t100\t11\t110\t1e-20\t80.5\n",
+		},
+		{
+			name: "UniProt taxid",
+			input: xmlIterations(xmlIterationElement("Query_1", "read1",
+				xmlHitElement("sp|P69905|HBA_HUMAN", "Hemoglobin subunit alpha OS=Homo sapiens OX=9606 GN=HBA1 PE=1 SV=2"))),
+			want: "read1\tsp|P69905|HBA_HUMAN\t90.00\t100\t8\t0\t1\t100\t11\t110\t1e-20\t80.5\t9606\n",
+		},
+		{
+			name: "UniRef taxid",
+			input: xmlIterations(xmlIterationElement("Query_1", "read1",
+				xmlHitElement("UniRef90_P69905", "Hemoglobin subunit alpha n=3 Tax=Homo sapiens TaxID=9606 RepID=HBA_HUMAN"))),
+			want: "read1\tUniRef90_P69905\t90.00\t100\t8\t0\t1\t100\t11\t110\t1e-20\t80.5\t9606\n",
+		},
+		{
+			name: "database without parsed seqids",
+			input: xmlIterations(xmlIterationElement("Query_1", "No definition line",
+				xmlHitElement("gnl|BL_ORD_ID|7", "gi|55|emb|X1| some sequence"))),
+			want: "Query_1\tgi|55|emb|X1|\t90.00\t100\t8\t0\t1\t100\t11\t110\t1e-20\t80.5\n",
+		},
+		{
+			name:  "query without hits",
+			input: xmlIterations(xmlIterationElement("Query_2", "read2")),
+			want:  "# Query: read2\n# 0 hits found\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := bufio.NewWriter(&out)
+			if err := ConvertXML(bufio.NewReader(strings.NewReader(tt.input)), nil, w); err != nil {
+				t.Fatalf("ConvertXML() error = %v", err)
+			}
+			w.Flush()
+			if got := out.String(); got != tt.want {
+				t.Errorf("ConvertXML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertXMLTruncated(t *testing.T) {
+	input := xmlIterations(xmlIterationElement("Query_1", "read1", xmlHitElement("gi|123|", "x")))
+	input = input[:len(input)/2]
+	w := bufio.NewWriter(&bytes.Buffer{})
+	if err := ConvertXML(bufio.NewReader(strings.NewReader(input)), nil, w); err == nil {
+		t.Errorf("ConvertXML() of a truncated file didn't fail")
+	}
+}
@@ -0,0 +1,195 @@
+// Package contig implements the classification of contigs and bins from the
+// taxonomic assignments of their predicted ORFs (genes) in the CAT/BAT fashion.
+//
+// Each ORF votes for its assigned taxon with its best bit score. A contig (or bin)
+// is classified to the deepest taxon supported by at least a given fraction of the
+// total bit score of its ORFs.
+package contig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+	"github.com/emepyc/Blast2lca/xopen"
+)
+
+// DefaultOrfRx is the default rule to obtain the contig name from the ORF name (Prodigal style: contig_12_3 => contig_12)
+const DefaultOrfRx = `^(.+)_[0-9]+$`
+
+// Result is the classification of a contig or bin
+type Result struct {
+	Name     string
+	Taxid    int     // -1 if it can't be classified
+	NOrfs    int     // ORFs in the contig or bin
+	NVoting  int     // ORFs with an assignment
+	Support  float64 // Fraction of the total bit score supporting Taxid
+	Bitscore float64 // Total bit score of the voting ORFs
+}
+
+type votes struct {
+	nOrfs, nVoting int
+	taxa           map[int]float64
+}
+
+// Classifier collects the votes of the ORFs of each contig.
+// It is safe for concurrent use
+type Classifier struct {
+	taxDB    *taxonomy.Taxonomy
+	orfRx    *regexp.Regexp
+	fraction float64
+	lock     sync.Mutex
+	contigs  map[string]*votes
+	order    []string // contigs in input order
+}
+
+// New creates a new Classifier. orfRx is a regular expression whose first capturing group is
+// the contig name of an ORF and fraction is the minimum fraction of bit score supporting a classification (0-1]
+func New(taxDB *taxonomy.Taxonomy, orfRx string, fraction float64) (*Classifier, error) {
+	if fraction <= 0 || fraction > 1 {
+		return nil, errors.New("Fraction of supporting bit score must be in the (0, 1] range")
+	}
+	rx, err := regexp.Compile(orfRx)
+	if err != nil {
+		return nil, err
+	}
+	if rx.NumSubexp() < 1 {
+		return nil, fmt.Errorf("ORF to contig rule %s has no capturing group", orfRx)
+	}
+	return &Classifier{
+		taxDB:    taxDB,
+		orfRx:    rx,
+		fraction: fraction,
+		contigs:  make(map[string]*votes),
+	}, nil
+}
+
+// ContigName returns the name of the contig of an ORF using the ORF to contig rule.
+// If the rule doesn't match the ORF name is returned
+func (c *Classifier) ContigName(orf []byte) string {
+	m := c.orfRx.FindSubmatch(orf)
+	if m == nil {
+		return string(orf)
+	}
+	return string(m[1])
+}
+
+// Add adds the vote of an assigned ORF (see blastm8.QueryRes.Assign)
+func (c *Classifier) Add(q *blastm8.QueryRes) {
+	name := c.ContigName(q.Query)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	v, ok := c.contigs[name]
+	if !ok {
+		v = &votes{taxa: make(map[int]float64)}
+		c.contigs[name] = v
+		c.order = append(c.order, name)
+	}
+	v.nOrfs++
+	if q.Taxid == -1 {
+		return
+	}
+	v.nVoting++
+	v.taxa[q.Taxid] += q.Stats.BestBitsc
+}
+
+func (c *Classifier) classify(name string, v *votes) *Result {
+	res := &Result{Name: name, Taxid: -1, NOrfs: v.nOrfs, NVoting: v.nVoting}
+	if v.nVoting == 0 {
+		return res
+	}
+	for _, bs := range v.taxa {
+		res.Bitscore += bs
+	}
+	res.Taxid = c.taxDB.WeightedLCA(v.taxa, c.fraction)
+	for taxid, bs := range v.taxa {
+		if taxid == res.Taxid || res.Taxid == 1 {
+			res.Support += bs
+			continue
+		}
+		for _, anc := range c.taxDB.Lineage(taxid) {
+			if anc == res.Taxid {
+				res.Support += bs
+				break
+			}
+		}
+	}
+	if res.Bitscore > 0 {
+		res.Support /= res.Bitscore
+	}
+	return res
+}
+
+// Contigs returns the classification of the contigs in input order
+func (c *Classifier) Contigs() []*Result {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	results := make([]*Result, 0, len(c.order))
+	for _, name := range c.order {
+		results = append(results, c.classify(name, c.contigs[name]))
+	}
+	return results
+}
+
+// Bins returns the classification of the bins given in contig2bin (contig name => bin name)
+// pooling the votes of all the ORFs of their contigs. Contigs not in contig2bin are ignored
+func (c *Classifier) Bins(contig2bin map[string]string) []*Result {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bins := make(map[string]*votes)
+	order := make([]string, 0)
+	for _, name := range c.order {
+		bin, ok := contig2bin[name]
+		if !ok {
+			continue
+		}
+		bv, ok := bins[bin]
+		if !ok {
+			bv = &votes{taxa: make(map[int]float64)}
+			bins[bin] = bv
+			order = append(order, bin)
+		}
+		cv := c.contigs[name]
+		bv.nOrfs += cv.nOrfs
+		bv.nVoting += cv.nVoting
+		for taxid, bs := range cv.taxa {
+			bv.taxa[taxid] += bs
+		}
+	}
+	results := make([]*Result, 0, len(order))
+	for _, bin := range order {
+		results = append(results, c.classify(bin, bins[bin]))
+	}
+	return results
+}
+
+// LoadBins reads a contig to bin mapping file (tab separated contig and bin names, one per line)
+func LoadBins(fname string) (map[string]string, error) {
+	buf, err := xopen.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	contig2bin := make(map[string]string)
+	for nline := 1; ; nline++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l := bytes.TrimSpace(line); len(l) > 0 && l[0] != '#' {
+			parts := bytes.Fields(l)
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("%s:%d: expected contig and bin names", fname, nline)
+			}
+			contig2bin[string(parts[0])] = string(parts[1])
+		}
+		if err == io.EOF {
+			return contig2bin, nil
+		}
+	}
+}
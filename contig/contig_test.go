@@ -0,0 +1,181 @@
+package contig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emepyc/Blast2lca/blastm8"
+	"github.com/emepyc/Blast2lca/taxonomy"
+)
+
+// testNodes is a small taxonomy: taxid, parent and rank
+var testNodes = [][3]string{
+	{"1", "1", "no rank"},
+	{"2", "1", "superkingdom"},
+	{"10", "2", "phylum"},
+	{"100", "10", "genus"},
+	{"101", "10", "genus"},
+	{"11", "2", "phylum"},
+	{"3", "1", "superkingdom"},
+	{"300", "3", "species"},
+}
+
+// newTestClassifier returns a Classifier of the test taxonomy with the default ORF to contig rule
+func newTestClassifier(t *testing.T, fraction float64) *Classifier {
+	t.Helper()
+	var nodes, names strings.Builder
+	for _, n := range testNodes {
+		nodes.WriteString(n[0] + "\t|\t" + n[1] + "\t|\t" + n[2] + "\t|\tXX\t|\n")
+		names.WriteString(n[0] + "\t|\ttaxon " + n[0] + "\t|\t\t|\tscientific name\t|\n")
+	}
+	dir := t.TempDir()
+	nodesfn, namesfn := filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp")
+	if err := os.WriteFile(nodesfn, []byte(nodes.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(namesfn, []byte(names.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	taxDB, err := taxonomy.New(nodesfn, namesfn, "", false)
+	if err != nil {
+		t.Fatalf("taxonomy.New() error = %v", err)
+	}
+	c, err := New(taxDB, DefaultOrfRx, fraction)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c
+}
+
+// addOrfs adds the ORFs of testOrfs to c
+func addOrfs(c *Classifier) {
+	for _, orf := range testOrfs {
+		c.Add(&blastm8.QueryRes{Query: blastm8.Header(orf.name), Taxid: orf.taxid, Stats: blastm8.Stats{BestBitsc: orf.bitsc}})
+	}
+}
+
+// testOrfs are the assignments of the ORFs of several contigs, interleaved
+var testOrfs = []struct {
+	name  string
+	taxid int
+	bitsc float64
+}{
+	{"c1_1", 100, 60},
+	{"c2_1", 300, 50},
+	{"c1_2", 101, 30},
+	{"c3_1", -1, 0},
+	{"c1_3", -1, 0},
+	{"c2_2", 100, 50},
+	{"c1_4", 100, 10},
+	{"orphan", 11, 5},
+}
+
+// describe returns the fields of the results as strings
+func describe(results []*Result) []string {
+	var desc []string
+	for _, r := range results {
+		desc = append(desc, fmt.Sprintf("%s %d %d/%d %.2f %.1f", r.Name, r.Taxid, r.NVoting, r.NOrfs, r.Support, r.Bitscore))
+	}
+	return desc
+}
+
+func TestContigs(t *testing.T) {
+	c := newTestClassifier(t, 0.6)
+	addOrfs(c)
+	want := []string{
+		"c1 100 3/4 0.70 100.0",  // Mixed ORF taxa, the majority passes the fraction
+		"c2 1 2/2 1.00 100.0",    // Different superkingdoms
+		"c3 -1 0/1 0.00 0.0",     // No ORF with an assignment
+		"orphan 11 1/1 1.00 5.0", // The rule doesn't match, the ORF is its own contig
+	}
+	if got := describe(c.Contigs()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Contigs() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBins(t *testing.T) {
+	c := newTestClassifier(t, 0.6)
+	addOrfs(c)
+	got := describe(c.Bins(map[string]string{"c2": "b1", "c1": "b1", "orphan": "b2"}))
+	want := []string{
+		"b1 100 5/6 0.60 200.0", // Pooled, 100 has 0.6 of the bit score although c2 alone is at the root
+		"b2 11 1/1 1.00 5.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Bins() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		orfRx    string
+		fraction float64
+		want     string
+	}{
+		{"zero fraction", DefaultOrfRx, 0, "Fraction of supporting bit score must be in the (0, 1] range"},
+		{"fraction over one", DefaultOrfRx, 1.5, "Fraction of supporting bit score must be in the (0, 1] range"},
+		{"invalid rule", "^(.+", 0.5, "missing closing )"},
+		{"rule without group", "^.+_[0-9]+$", 0.5, "has no capturing group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nil, tt.orfRx, tt.fraction); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New(%q, %v) error = %v, want %q", tt.orfRx, tt.fraction, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBins(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "blank lines and comments",
+			input: "# contig\tbin\nc1\tb1\n\n  \t\nc2 b2\textra\n",
+			want:  map[string]string{"c1": "b1", "c2": "b2"},
+		},
+		{
+			name:  "no final newline",
+			input: "c1\tb1\nc2\tb1",
+			want:  map[string]string{"c1": "b1", "c2": "b1"},
+		},
+		{
+			name:  "malformed line",
+			input: "c1\tb1\n\nc2\n",
+			err:   ":3: expected contig and bin names",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "bins.tsv")
+			if err := os.WriteFile(fname, []byte(tt.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadBins(fname)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), fname+tt.err) {
+					t.Errorf("LoadBins() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadBins() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadBins() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := LoadBins(filepath.Join(t.TempDir(), "missing.tsv")); err == nil {
+		t.Errorf("LoadBins() of a missing file didn't fail")
+	}
+}
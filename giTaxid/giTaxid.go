@@ -8,10 +8,11 @@ import (
 	"bufio"
 	"bytes"
 	"strconv"
-	"sync"
 	"errors"
 	"io"
 	"log"
+
+	"github.com/emepyc/Blast2lca/xopen"
 )
 
 const posJump = -100 // We will only read the last 100bp
@@ -26,10 +27,10 @@ type GiMapper interface {
 type OnMemory []uint8
 
 // OnFile is the type of the GiTaxid mapper kept in file
+// It is safe for concurrent use (reads are done with ReadAt)
 type OnFile struct {
 	FileMap *os.File
 	FileLen int64
-	lock *sync.Mutex
 }
 
 // GiTaxid maps Gi to Taxids
@@ -46,32 +47,18 @@ func (m OnMemory) GiTaxid (gi int) ( int, error ) {
 
 // GiTaxid maps Gi to Taxids
 func (r *OnFile) GiTaxid ( gi int ) ( int, error ) {
-//	fmt.Fprintf(os.Stderr, "----> GI : %d\n", gi)
-
-	r.lock.Lock()
-	f := r.FileMap
 	pos := int64(gi * 3)
 
-	if pos >= r.FileLen {   // 3 bytes
+	if pos < 0 || pos+3 > r.FileLen {   // 3 bytes
 		return -1, errors.New(fmt.Sprintf("GI too high: %d\n", gi))
 	}
 
-	newpos, err := f.Seek(pos, os.SEEK_SET)
-	// Seeking beyond EOF doesn't report error
-	if newpos != pos {
-		return -1, errors.New(fmt.Sprintf("Can't seek to pos %d (maybe too high?)\n", pos))
-	}
-	if err != nil {
-		return -1, err
-	}
-
 	bts := make([]byte, 3)
-	n, err := f.Read(bts)
-	r.lock.Unlock()
+	n, err := r.FileMap.ReadAt(bts, pos)
 	if n != 3 {
 		return -1, errors.New(fmt.Sprintf("Can't read for GI %d\n", gi))
 	}
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return -1, err
 	}
 
@@ -80,18 +67,27 @@ func (r *OnFile) GiTaxid ( gi int ) ( int, error ) {
 }
 
 func readLastGI (fname string) (gi int, ok bool) {
-	fh, err := os.Open(fname)
+	zbuf, err := xopen.Open(fname)
 	if err != nil {
 		log.Printf("WARNING: Unable to open file: %s\n", err)
 		return
 	}
-	defer fh.Close()
-	_, e := fh.Seek(posJump, 2)  // We read only the last part of the file
-	if e != nil {
-		log.Printf("WARNING: Unable to seek file: %s\n", e)
-		return
+	defer zbuf.Close()
+	buf := zbuf.Reader // Compressed files can't be seeked, they are read to the end
+	if zbuf.Format == xopen.Plain {
+		fh, err := os.Open(fname)
+		if err != nil {
+			log.Printf("WARNING: Unable to open file: %s\n", err)
+			return
+		}
+		defer fh.Close()
+		_, e := fh.Seek(posJump, 2)  // We read only the last part of the file
+		if e != nil {
+			log.Printf("WARNING: Unable to seek file: %s\n", e)
+			return
+		}
+		buf = bufio.NewReader(fh)
 	}
-	buf := bufio.NewReader(fh)
 	prevLine, isIndex , err := buf.ReadLine()
 	if err != nil {
 		log.Printf("WARNING: Unable to read file: %s\n", err)
@@ -154,9 +150,8 @@ func (m OnMemory) encode (gi, taxid int) {
 }
 
 
-// loadTextMapper incorporates the fh file mapper to m
-func (m OnMemory) loadTextMapper (fh *os.File) error {
-	buf := bufio.NewReader(fh)
+// loadTextMapper incorporates the buf file mapper to m
+func (m OnMemory) loadTextMapper (buf *bufio.Reader) error {
 	for {
 		line, _ , err := buf.ReadLine()
 		if err == io.EOF {
@@ -191,13 +186,13 @@ func New (files []string) (OnMemory, error) {
 
 	for _, file := range files {
 		log.Printf("(%s) ... ", file)
-		fh, err := os.Open(file)
+		fh, err := xopen.Open(file)
 		if err != nil {
 			return nil, err
 		}
 		defer fh.Close()
 
-		m.loadTextMapper(fh)
+		m.loadTextMapper(fh.Reader)
 
 		t2 := time.Now()
 		dur := t2.Sub(t1)
@@ -245,7 +240,6 @@ func Load (fname string, savemem bool) ( GiMapper, error ) {
 		return &OnFile {
 		FileMap : fh,
 		FileLen : d.Size(),
-		lock : new(sync.Mutex),
 		}, nil
 	}
 	fh, err := os.OpenFile(fname, os.O_RDONLY, 0)
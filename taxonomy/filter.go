@@ -0,0 +1,154 @@
+package taxonomy
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emepyc/Blast2lca/xopen"
+)
+
+// Presets are built-in collections of name patterns for uninformative taxa.
+// They can be used by name in the exclusion and inclusion lists
+var Presets = map[string][]string{
+	"environmental": {`^environmental samples$`},
+	"uncultured":    {`^uncultured `},
+	"unclassified":  {`^unclassified sequences$`},
+	"synthetic":     {`^synthetic construct$`},
+	"vectors":       {`^vectors$`},
+	"metagenomes":   {`^metagenomes$`, ` metagenome$`},
+}
+
+func init() {
+	all := make([]string, 0, 10)
+	for _, pats := range Presets {
+		all = append(all, pats...)
+	}
+	Presets["uninformative"] = all
+}
+
+// Filter decides which taxids can take part in an LCA calculation.
+// A taxid is excluded if it is in one of the excluded subtrees, or if
+// there are inclusion subtrees and it is not in any of them.
+// Filters are precomputed over the whole taxonomy and are safe for concurrent use
+type Filter struct {
+	d    map[int]int // from taxids to internal node ids
+	keep []bool      // indexed by internal node id
+}
+
+type filterSet struct {
+	taxids map[int]bool
+	rxs    []*regexp.Regexp
+}
+
+func (s *filterSet) empty() bool {
+	return len(s.taxids) == 0 && len(s.rxs) == 0
+}
+
+func (s *filterSet) match(n *taxnode) bool {
+	if s.taxids[n.Taxid] {
+		return true
+	}
+	for _, rx := range s.rxs {
+		if rx.Match(n.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds an item to the set. Items can be taxids, preset names,
+// "@file" (one item per line, # for comments) or name patterns (case insensitive regexps)
+func (s *filterSet) add(item string) error {
+	item = strings.TrimSpace(item)
+	if item == "" || item[0] == '#' {
+		return nil
+	}
+	if taxid, err := strconv.Atoi(item); err == nil {
+		s.taxids[taxid] = true
+		return nil
+	}
+	if pats, ok := Presets[item]; ok {
+		for _, pat := range pats {
+			if err := s.add(pat); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if item[0] == '@' {
+		fh, err := xopen.Open(item[1:])
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		sc := bufio.NewScanner(fh)
+		for sc.Scan() {
+			if err := s.add(sc.Text()); err != nil {
+				return err
+			}
+		}
+		return sc.Err()
+	}
+	rx, err := regexp.Compile("(?i)" + item)
+	if err != nil {
+		return fmt.Errorf("Invalid name pattern %s: %s", item, err)
+	}
+	s.rxs = append(s.rxs, rx)
+	return nil
+}
+
+func newFilterSet(spec string) (*filterSet, error) {
+	s := &filterSet{taxids: make(map[int]bool)}
+	if spec == "" {
+		return s, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		if err := s.add(item); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewFilter creates a Filter from the exclude and include specifications.
+// Both are comma separated lists of taxids (whole subtrees), preset names (see Presets),
+// name patterns or "@file"s with one of them per line. Empty specifications are ignored
+func (t *Taxonomy) NewFilter(exclude, include string) (*Filter, error) {
+	exc, err := newFilterSet(exclude)
+	if err != nil {
+		return nil, err
+	}
+	inc, err := newFilterSet(include)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{d: t.D, keep: make([]bool, len(t.T)+1)}
+	t.T.fillFilter(1, false, inc.empty(), exc, inc, f.keep)
+	return f, nil
+}
+
+func (t taxTree) fillFilter(node int, excluded, included bool, exc, inc *filterSet, keep []bool) {
+	n := t[node]
+	excluded = excluded || exc.match(n)
+	included = included || inc.match(n)
+	keep[node] = included && !excluded
+	for _, child := range n.Childs {
+		t.fillFilter(child, excluded, included, exc, inc, keep)
+	}
+}
+
+// Excluded reports whether taxid has been left out by the filter.
+// Taxids not in the taxonomy are never excluded. A nil Filter excludes nothing
+func (f *Filter) Excluded(taxid int) bool {
+	if f == nil {
+		return false
+	}
+	id, ok := f.d[taxid]
+	if !ok || id >= len(f.keep) {
+		return false
+	}
+	return !f.keep[id]
+}
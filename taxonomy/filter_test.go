@@ -0,0 +1,84 @@
+package taxonomy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	listfn := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := os.WriteFile(listfn, []byte("# Uninformative taxa\nvectors\n\n300\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name             string
+		exclude, include string
+		want             []int // Excluded taxids of testNodes
+	}{
+		{name: "no filter"},
+		{name: "environmental preset", exclude: "environmental", want: []int{12, 120}},
+		{name: "uncultured preset", exclude: "uncultured", want: []int{120}},
+		{name: "unclassified preset", exclude: "unclassified", want: []int{12908, 408169, 408170}},
+		{name: "synthetic preset", exclude: "synthetic", want: []int{32630}},
+		{name: "vectors preset", exclude: "vectors", want: []int{29278}},
+		{name: "metagenomes preset", exclude: "metagenomes", want: []int{408169, 408170}},
+		{name: "uninformative preset", exclude: "uninformative", want: []int{12, 120, 32630, 29278, 12908, 408169, 408170}},
+		{name: "case insensitive name pattern", exclude: "^HOMO", want: []int{30, 300}},
+		{name: "taxid subtree", exclude: "10", want: []int{10, 100, 101}},
+		{name: "several items", exclude: " 11 , synthetic", want: []int{11, 32630}},
+		{name: "list file", exclude: "@" + listfn, want: []int{300, 29278}},
+		{name: "inclusion", include: "3", want: []int{1, 2, 10, 100, 101, 11, 12, 120, 28384, 32630, 29278, 12908, 408169, 408170}},
+		{name: "inclusion and exclusion", exclude: "uncultured", include: "2,Homo", want: []int{1, 120, 3, 28384, 32630, 29278, 12908, 408169, 408170}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tax.NewFilter(tt.exclude, tt.include)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+			var got []int
+			for _, n := range testNodes {
+				if f.Excluded(n.taxid) {
+					got = append(got, n.taxid)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewFilter(%q, %q) excludes %v, want %v", tt.exclude, tt.include, got, tt.want)
+			}
+			if f.Excluded(999) {
+				t.Errorf("NewFilter(%q, %q) excludes a taxid that is not in the taxonomy", tt.exclude, tt.include)
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	tests := []struct {
+		name             string
+		exclude, include string
+		want             string
+	}{
+		{"invalid pattern", "environmental,(", "", "Invalid name pattern ("},
+		{"invalid inclusion pattern", "", "[a-", "Invalid name pattern [a-"},
+		{"missing list file", "@" + filepath.Join(t.TempDir(), "missing.txt"), "", "missing.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tax.NewFilter(tt.exclude, tt.include); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewFilter(%q, %q) error = %v, want %q", tt.exclude, tt.include, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	var f *Filter
+	if f.Excluded(100) {
+		t.Errorf("A nil Filter excludes taxa")
+	}
+}
@@ -70,8 +70,8 @@ func lcaHelper(E, L, H []int, M [][]int, i, j int) int {
 	if i == j {
 		return i
 	}
-	v1 := H[i-1]
-	v2 := H[j-1]
+	v1 := H[i-1] - 1 // HINT: H keeps 1-based positions in E
+	v2 := H[j-1] - 1
 	if v1 > v2 {
 		v1, v2 = v2, v1
 	}
@@ -79,3 +79,30 @@ func lcaHelper(E, L, H []int, M [][]int, i, j int) int {
 	lca := E[rmq]
 	return lca
 }
+
+// WeightedLCA returns the deepest taxon whose subtree accumulates at least
+// cover of the total weight given to the taxids in weights.
+// Ties (only possible when cover <= 0.5) are resolved in favour of the heaviest taxon
+func (t Taxonomy) WeightedLCA(weights map[int]float64, cover float64) int {
+	total := float64(0)
+	cumul := make(map[int]float64)
+	depth := make(map[int]int)
+	for taxid, w := range weights {
+		total += w
+		lineage := t.Lineage(taxid)
+		for i, anc := range lineage {
+			cumul[anc] += w
+			depth[anc] = len(lineage) - i
+		}
+	}
+	best := 1
+	for taxid, w := range cumul {
+		if w < cover*total {
+			continue
+		}
+		if depth[taxid] > depth[best] || (depth[taxid] == depth[best] && (w > cumul[best] || (w == cumul[best] && taxid < best))) {
+			best = taxid
+		}
+	}
+	return best
+}
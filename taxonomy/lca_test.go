@@ -0,0 +1,199 @@
+package taxonomy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testNodes is a small taxonomy. The taxa are written to nodes.dmp in this order, which is
+// also the order of the Euler tour: the metagenome is the last node it reaches
+var testNodes = []struct {
+	taxid, parent int
+	rank, name    string
+}{
+	{1, 1, "no rank", "root"},
+	{2, 1, "superkingdom", "Bacteria"},
+	{10, 2, "phylum", "Proteobacteria"},
+	{100, 10, "genus", "Escherichia"},
+	{101, 10, "genus", "Salmonella"},
+	{11, 2, "phylum", "Firmicutes"},
+	{12, 2, "no rank", "environmental samples"},
+	{120, 12, "species", "uncultured bacterium"},
+	{3, 1, "superkingdom", "Eukaryota"},
+	{30, 3, "genus", "Homo"},
+	{300, 30, "species", "Homo sapiens"},
+	{28384, 1, "no rank", "other sequences"},
+	{32630, 28384, "species", "synthetic construct"},
+	{29278, 28384, "no rank", "vectors"},
+	{12908, 1, "no rank", "unclassified sequences"},
+	{408169, 12908, "no rank", "metagenomes"},
+	{408170, 408169, "species", "human gut metagenome"},
+}
+
+// newTestTaxonomy loads testNodes without a GI dictionary
+func newTestTaxonomy(t *testing.T) *Taxonomy {
+	t.Helper()
+	var nodes, names strings.Builder
+	for _, n := range testNodes {
+		fmt.Fprintf(&nodes, "%d\t|\t%d\t|\t%s\t|\tXX\t|\n", n.taxid, n.parent, n.rank)
+		fmt.Fprintf(&names, "%d\t|\t%s\t|\t\t|\tscientific name\t|\n", n.taxid, n.name)
+	}
+	dir := t.TempDir()
+	nodesfn, namesfn := filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp")
+	if err := os.WriteFile(nodesfn, []byte(nodes.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(namesfn, []byte(names.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tax, err := New(nodesfn, namesfn, "", false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return tax
+}
+
+// naiveLCA walks up the lineage of b until it finds an ancestor of a
+func naiveLCA(tax *Taxonomy, a, b int) int {
+	ancestors := map[int]bool{1: true}
+	for _, anc := range tax.Lineage(a) {
+		ancestors[anc] = true
+	}
+	for _, anc := range tax.Lineage(b) {
+		if ancestors[anc] {
+			return anc
+		}
+	}
+	return 1
+}
+
+func TestLCA(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	tests := []struct {
+		name   string
+		taxids []int
+		want   int
+	}{
+		{"siblings", []int{100, 101}, 10},
+		{"cousins", []int{100, 11}, 2},
+		{"ancestor first", []int{10, 100}, 10},
+		{"descendant first", []int{100, 10}, 10},
+		{"same taxon", []int{100, 100}, 100},
+		{"single taxon", []int{300}, 300},
+		{"root", []int{1, 300}, 1},
+		{"different superkingdoms", []int{100, 300}, 1},
+		{"several taxa", []int{100, 101, 11}, 2},
+		{"taxa not in the taxonomy are ignored", []int{100, 999, 101}, 10},
+		{"last node of the tour and its parent", []int{408170, 408169}, 408169},
+		{"last node of the tour and an ancestor", []int{12908, 408170}, 12908},
+		{"last node of the tour and a leaf", []int{408170, 120}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := tax.LCA(tt.taxids...)
+			if err != nil {
+				t.Fatalf("LCA(%v) error = %v", tt.taxids, err)
+			}
+			if node.Taxid != tt.want {
+				t.Errorf("LCA(%v) = %d, want %d", tt.taxids, node.Taxid, tt.want)
+			}
+		})
+	}
+}
+
+func TestLCALastNode(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	last := 1
+	for id := range tax.T {
+		if tax.H[id-1] > tax.H[last-1] {
+			last = id
+		}
+	}
+	if tax.T[last].Taxid != 408170 {
+		t.Fatalf("The last node of the Euler tour is %d, the tests expect 408170", tax.T[last].Taxid)
+	}
+	if first := tax.H[last-1]; first <= len(tax.T) {
+		t.Fatalf("The first visit to the last node is at %d, the tests expect it in the second half of the tour (> %d)", first, len(tax.T))
+	}
+}
+
+func TestLCAAllPairs(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	for _, a := range testNodes {
+		for _, b := range testNodes {
+			node, err := tax.LCA(a.taxid, b.taxid)
+			if err != nil {
+				t.Fatalf("LCA(%d, %d) error = %v", a.taxid, b.taxid, err)
+			}
+			if want := naiveLCA(tax, a.taxid, b.taxid); node.Taxid != want {
+				t.Errorf("LCA(%d, %d) = %d, want %d", a.taxid, b.taxid, node.Taxid, want)
+			}
+		}
+	}
+}
+
+func TestLCAEmpty(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	if _, err := tax.LCA(999); err == nil {
+		t.Errorf("LCA() of taxa not in the taxonomy didn't fail")
+	}
+}
+
+func TestLCAHelper(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	if h10, h100 := tax.H[tax.D[10]-1], tax.H[tax.D[100]-1]; h100 != h10+1 {
+		t.Fatalf("The first visits to 10 and 100 are at %d and %d, the tests expect them adjacent", h10, h100)
+	}
+	tests := []struct {
+		name string
+		a, b int
+		want int
+	}{
+		{"same node", 100, 100, 100},
+		{"adjacent first visits", 10, 100, 10},
+		{"adjacent first visits reversed", 100, 10, 10},
+		{"siblings", 100, 101, 10},
+		{"siblings reversed", 101, 100, 10},
+		{"root", 1, 408170, 1},
+		{"last node of the tour reversed", 408170, 300, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lcaHelper(tax.E, tax.L, tax.H, tax.M, tax.D[tt.a], tax.D[tt.b])
+			if taxid := tax.T[got].Taxid; taxid != tt.want {
+				t.Errorf("lcaHelper(%d, %d) = %d, want %d", tt.a, tt.b, taxid, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedLCA(t *testing.T) {
+	tax := newTestTaxonomy(t)
+	tests := []struct {
+		name    string
+		weights map[int]float64
+		cover   float64
+		want    int
+	}{
+		{"single taxon", map[int]float64{100: 1}, 1, 100},
+		{"majority over the cover", map[int]float64{100: 3, 101: 1}, 0.75, 100},
+		{"majority under the cover", map[int]float64{100: 3, 101: 1}, 0.8, 10},
+		{"weight of an ancestor", map[int]float64{10: 1, 100: 1}, 0.6, 10},
+		{"different superkingdoms", map[int]float64{100: 1, 300: 1}, 0.6, 1},
+		{"tie broken by weight", map[int]float64{100: 2, 101: 3}, 0.4, 101},
+		{"tie broken by taxid", map[int]float64{100: 1, 101: 1}, 0.5, 100},
+		{"deeper taxon over a heavier one", map[int]float64{300: 2, 100: 1, 101: 1}, 0.5, 300},
+		{"taxa not in the taxonomy count in the total", map[int]float64{100: 1, 999: 1}, 0.6, 1},
+		{"no weights", map[int]float64{}, 0.6, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tax.WeightedLCA(tt.weights, tt.cover); got != tt.want {
+				t.Errorf("WeightedLCA(%v, %v) = %d, want %d", tt.weights, tt.cover, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@
 package taxonomy
 
 import (
+	"errors"
 //	"log"
 	"fmt"
 	"os"
@@ -15,8 +16,10 @@ import (
 	"strconv"
 	"time"
 	"math"
+	"sort"
 	"github.com/emepyc/Blast2lca/giTaxid"
 	"github.com/emepyc/Blast2lca/wcl"
+	"github.com/emepyc/Blast2lca/xopen"
 )
 // TODO : Factor out LCA code in a different source file
 const sep = "\t|\t"
@@ -163,13 +166,12 @@ func parsename(l []byte) (int, []byte, error) {
 }
 
 func (t taxTree) loadNames (fname string, dict map[int]int) error {
-	namesf, eopen := os.OpenFile(fname, os.O_RDONLY, 0644)
-	defer namesf.Close()
+	b, eopen := xopen.Open(fname)
 	if eopen != nil {
 		fmt.Fprintf(os.Stderr, "file doesn't exist %s\n", fname)
 		return eopen
 	}
-	b := bufio.NewReader(io.Reader(namesf))
+	defer b.Close()
 	for {
 		line, _,  err := b.ReadLine()
 		if err == io.EOF {
@@ -275,7 +277,8 @@ func newTaxonomy(b *bufio.Reader, maxNodes int) (taxTree, map[int]int, error) {
 
 
 // New creates a new NCBI taxonomy representation from the options given in opts
-// returns the newly created taxonomy or any error it may encounter in the process
+// returns the newly created taxonomy or any error it may encounter in the process.
+// Without dictfn no gi to taxid mapping is loaded (and the FromGi methods can't be used)
 func New(nodesfn, namesfn, dictfn string, savemem bool) (*Taxonomy, error) {
 	// T : taxtree => tax
 	t := &Taxonomy{}
@@ -285,13 +288,13 @@ func New(nodesfn, namesfn, dictfn string, savemem bool) (*Taxonomy, error) {
 	if ewcl != nil {
 		return nil, ewcl
 	}
-	nodesf, eopen := os.OpenFile(nodesfn, os.O_RDONLY, 0644)
+	nodesbuf, eopen := xopen.Open(nodesfn)
 	if eopen != nil {
 		return nil, eopen
 	}
-	nodesbuf := bufio.NewReader(io.Reader(nodesf))
+	defer nodesbuf.Close()
 
-	tax, dict, ee := newTaxonomy(nodesbuf, maxNodes)
+	tax, dict, ee := newTaxonomy(nodesbuf.Reader, maxNodes)
 	if ee != nil {
 		return nil, ee
 	}
@@ -325,7 +328,7 @@ func New(nodesfn, namesfn, dictfn string, savemem bool) (*Taxonomy, error) {
 	fmt.Fprintf(os.Stderr, "Preprocessing RMQ ... ")
 	s1 = time.Now()
 	M := makeMatrix(maxNodes)
-	rmqPrep(&M, L[:len(L)-1], maxNodes)
+	rmqPrep(&M, L[:len(L)-1], len(L)-1)
 	s2 = time.Now()
 	dur = s2.Sub(s1)
 	fmt.Fprintf(os.Stderr, "Done (%.3f sec)\n", dur.Seconds())
@@ -353,6 +356,9 @@ func New(nodesfn, namesfn, dictfn string, savemem bool) (*Taxonomy, error) {
 	dur = s2.Sub(s1)
 	fmt.Fprintf(os.Stderr, "Done (%.3f sec)\n", dur.Seconds())
 
+	if dictfn == "" {
+		return t, nil
+	}
 	t.G, err = giTaxid.Load(dictfn, savemem)
 	if err != nil {
 		return nil, err
@@ -392,6 +398,56 @@ func (t Taxonomy) Path(taxid int) []*pathnode {
 	return nil
 }
 
+// Lineage returns the taxids from taxid up to (but not including) the root
+// or nil if taxid is not in the taxonomy
+func (t Taxonomy) Lineage(taxid int) []int {
+	node := t.Node(taxid)
+	if node == nil {
+		return nil
+	}
+	lineage := make([]int, 0, 20)
+	for node.Taxid != 1 {
+		lineage = append(lineage, node.Taxid)
+		node = t.T[node.Parent]
+	}
+	return lineage
+}
+
+// Children returns the taxids of the children of taxid
+// or nil if taxid is not in the taxonomy
+func (t Taxonomy) Children(taxid int) []int {
+	node := t.Node(taxid)
+	if node == nil {
+		return nil
+	}
+	children := make([]int, 0, len(node.Childs))
+	for _, child := range node.Childs {
+		children = append(children, t.T[child].Taxid)
+	}
+	return children
+}
+
+// NameIndex returns the taxids of the taxa with each name.
+// A name can have several taxids (homonyms of different ranks or kingdoms), sorted in increasing order
+func (t Taxonomy) NameIndex() map[string][]int {
+	index := make(map[string][]int, len(t.T))
+	for _, node := range t.T {
+		index[string(node.Name)] = append(index[string(node.Name)], node.Taxid)
+	}
+	for _, taxids := range index {
+		if len(taxids) > 1 {
+			sort.Ints(taxids)
+		}
+	}
+	return index
+}
+
+// Has reports whether taxid is present in the taxonomy
+func (t Taxonomy) Has(taxid int) bool {
+	_, ok := t.D[taxid]
+	return ok
+}
+
 //TODO: Unexport this function?
 func (t *Taxonomy) PathFromGi(gi int) ([]*pathnode, error) {
 	taxid, err := t.TaxidFromGi(gi)
@@ -401,8 +457,17 @@ func (t *Taxonomy) PathFromGi(gi int) ([]*pathnode, error) {
 	return t.Path(taxid), nil
 }
 
+// ErrNoDict is returned by TaxidFromGi if the taxonomy was loaded without GI dictionary
+var ErrNoDict = errors.New("No GI dictionary loaded")
+
 // TaxidFromGi returns the Taxid associated with a given GI
 func (t *Taxonomy) TaxidFromGi(gi int) (int, error) {
+	if t.G == nil {
+		return -1, ErrNoDict
+	}
+	if gi < 0 {
+		return -1, fmt.Errorf("Invalid GI %d", gi)
+	}
 	taxid, err := t.G.GiTaxid(gi)
 	if err != nil {
 		return -1, err
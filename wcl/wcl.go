@@ -1,24 +1,26 @@
 package wcl
 
 import (
-	"os"
 	"io"
-	"bufio"
+
+	"github.com/emepyc/Blast2lca/xopen"
 )
 
 func FromFile (fname string) (int, error) {
 	nlines := 0
-	fh, err := os.Open(fname)
-	defer fh.Close()
+	buf, err := xopen.Open(fname)
 	if err != nil {
 		return -1, err
 	}
-	buf := bufio.NewReader(fh)
+	defer buf.Close()
 	for {
 		_, _, err := buf.ReadLine()
 		if err == io.EOF {
 			return nlines, nil
 		}
+		if err != nil {
+			return -1, err
+		}
 		nlines++
 	}
 	return nlines, nil // never used
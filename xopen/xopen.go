@@ -0,0 +1,262 @@
+// Package xopen opens the input and output files of the commands.
+//
+// The name "-" stands for the standard input (or output). Compressed inputs are
+// detected by their magic bytes and decompressed transparently: gzip and bzip2 are
+// read with the standard library and zstd with the external zstd command.
+// Outputs are compressed according to their extension (.gz, .bz2 or .zst),
+// bzip2 and zstd compression use the external bzip2 and zstd commands.
+package xopen
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Stdio is the name of the standard input and output
+const Stdio = "-"
+
+// DefaultBufferSize is the buffer size of the readers and writers returned by Open and Create
+const DefaultBufferSize = 64 * 1024
+
+// Format is the compression format of a file
+type Format int
+
+const (
+	Plain Format = iota
+	Gzip
+	Bzip2
+	Zstd
+)
+
+var formatNames = [...]string{"plain", "gzip", "bzip2", "zstd"}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+var magics = []struct {
+	format Format
+	magic  []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Bzip2, []byte("BZh")},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// detect returns the format of the stream from its first bytes
+func detect(b *bufio.Reader) Format {
+	head, _ := b.Peek(4)
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return Plain
+}
+
+// FormatOf returns the compression format implied by the extension of the file name
+func FormatOf(name string) Format {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return Gzip
+	case strings.HasSuffix(name, ".bz2"):
+		return Bzip2
+	case strings.HasSuffix(name, ".zst"):
+		return Zstd
+	}
+	return Plain
+}
+
+// Reader is a buffered reader of the decompressed contents of a file
+type Reader struct {
+	*bufio.Reader
+	Format Format // Compression format of the file
+	file   *os.File
+	closer io.Closer
+}
+
+// Open opens the file (or the standard input for "-") for reading, decompressing it if needed
+func Open(name string) (*Reader, error) {
+	return OpenSize(name, DefaultBufferSize)
+}
+
+// OpenSize is Open with a buffer of the given size
+func OpenSize(name string, size int) (*Reader, error) {
+	r := &Reader{file: os.Stdin}
+	if name != Stdio {
+		fh, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		r.file = fh
+	}
+	raw := bufio.NewReaderSize(r.file, size)
+	r.Format = detect(raw)
+	var err error
+	switch r.Format {
+	case Plain:
+		r.Reader = raw
+		return r, nil
+	case Gzip:
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(raw)
+		if err == nil {
+			r.Reader, r.closer = bufio.NewReaderSize(gz, size), gz
+		}
+	case Bzip2:
+		r.Reader = bufio.NewReaderSize(bzip2.NewReader(raw), size)
+	case Zstd:
+		cr := &cmdReader{cmd: exec.Command("zstd", "-dcq")}
+		cr.cmd.Stdin = raw
+		cr.cmd.Stderr = os.Stderr
+		cr.out, err = cr.cmd.StdoutPipe()
+		if err == nil {
+			err = cr.cmd.Start()
+		}
+		if err == nil {
+			r.Reader, r.closer = bufio.NewReaderSize(cr, size), cr
+		}
+	}
+	if err != nil {
+		r.closeFile()
+		return nil, fmt.Errorf("Unable to decompress %s (%s): %s", name, r.Format, err)
+	}
+	return r, nil
+}
+
+// ReaderAt returns the file for reading at random positions if it is an uncompressed regular file (nil otherwise)
+func (r *Reader) ReaderAt() io.ReaderAt {
+	if r.Format != Plain || r.file == os.Stdin {
+		return nil
+	}
+	if st, err := r.file.Stat(); err != nil || !st.Mode().IsRegular() {
+		return nil
+	}
+	return r.file
+}
+
+func (r *Reader) closeFile() error {
+	if r.file == os.Stdin {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// Close closes the file and stops the external decompressor (if any)
+func (r *Reader) Close() error {
+	var err error
+	if r.closer != nil {
+		err = r.closer.Close()
+	}
+	if ferr := r.closeFile(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// cmdReader reads the output of an external decompressor, whose failures are reported at the end of the output
+type cmdReader struct {
+	cmd  *exec.Cmd
+	out  io.ReadCloser
+	done bool
+}
+
+func (c *cmdReader) Read(p []byte) (int, error) {
+	n, err := c.out.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		if werr := c.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("%s failed: %s", c.cmd.Args[0], werr)
+		}
+	}
+	return n, err
+}
+
+// Close stops the decompressor if the output was not read to the end
+func (c *cmdReader) Close() error {
+	if c.done {
+		return nil
+	}
+	c.done = true
+	c.out.Close()
+	c.cmd.Wait() // Killed by the closed pipe
+	return nil
+}
+
+// Writer is a buffered writer that compresses its output according to the file extension
+type Writer struct {
+	*bufio.Writer
+	Format Format // Compression format of the file
+	file   *os.File
+	closer io.Closer
+	cmd    *exec.Cmd
+}
+
+// Create creates (or truncates) the file for writing (the standard output for "-").
+// The output is compressed according to the extension of the name (see FormatOf).
+// The writer must be closed to flush all the output
+func Create(name string) (*Writer, error) {
+	w := &Writer{file: os.Stdout}
+	if name != Stdio {
+		fh, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		w.file = fh
+		w.Format = FormatOf(name)
+	}
+	var err error
+	switch w.Format {
+	case Plain:
+		w.Writer = bufio.NewWriterSize(w.file, DefaultBufferSize)
+	case Gzip:
+		gz := gzip.NewWriter(w.file)
+		w.Writer, w.closer = bufio.NewWriterSize(gz, DefaultBufferSize), gz
+	case Bzip2, Zstd:
+		w.cmd = exec.Command(map[Format]string{Bzip2: "bzip2", Zstd: "zstd"}[w.Format], "-cq")
+		w.cmd.Stdout = w.file
+		w.cmd.Stderr = os.Stderr
+		var in io.WriteCloser
+		in, err = w.cmd.StdinPipe()
+		if err == nil {
+			err = w.cmd.Start()
+		}
+		if err == nil {
+			w.Writer, w.closer = bufio.NewWriterSize(in, DefaultBufferSize), in
+		}
+	}
+	if err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("Unable to compress %s (%s): %s", name, w.Format, err)
+	}
+	return w, nil
+}
+
+// Close flushes the output, finishes the compression and closes the file
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if w.cmd != nil {
+		if werr := w.cmd.Wait(); err == nil && werr != nil {
+			err = fmt.Errorf("%s compression failed: %s", w.Format, werr)
+		}
+	}
+	if w.file == os.Stdout {
+		return err
+	}
+	if ferr := w.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
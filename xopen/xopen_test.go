@@ -0,0 +1,159 @@
+package xopen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const content = "q1\tgi|1|\n"
+
+// bzip2Content is content compressed with bzip2 (the standard library has no bzip2 writer)
+var bzip2Content = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x50, 0x24, 0x37, 0xf7, 0x00, 0x00,
+	0x03, 0x49, 0x80, 0x00, 0x30, 0x20, 0x00, 0x00, 0xa0, 0x20, 0x04, 0x20, 0x00, 0x31, 0x0c, 0x01,
+	0x01, 0xb2, 0x9a, 0xb9, 0xc0, 0x3e, 0x2e, 0xe4, 0x8a, 0x70, 0xa1, 0x20, 0xa0, 0x48, 0x6f, 0xee,
+}
+
+func gzipContent(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAll opens path and returns its format and contents
+func readAll(t *testing.T, path string) (Format, string) {
+	t.Helper()
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Reading %s: %v", path, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return r.Format, string(got)
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+		want   string
+	}{
+		{"plain", []byte(content), Plain, content},
+		{"plain shorter than the magic bytes", []byte("q\n"), Plain, "q\n"},
+		{"empty", nil, Plain, ""},
+		{"gzip", gzipContent(t), Gzip, content},
+		{"bzip2", bzip2Content, Bzip2, content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hits") // Detected without extension
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			format, got := readAll(t, path)
+			if format != tt.format || got != tt.want {
+				t.Errorf("Open() = %s %q, want %s %q", format, got, tt.format, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Open() of a missing file didn't fail")
+	}
+	truncated := filepath.Join(dir, "truncated.gz")
+	if err := os.WriteFile(truncated, []byte{0x1f, 0x8b}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(truncated); err == nil || !strings.Contains(err.Error(), "Unable to decompress") {
+		t.Errorf("Open() of a truncated gzip file error = %v", err)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for name, want := range map[string]Format{"a.m8": Plain, "a.m8.gz": Gzip, "a.bz2": Bzip2, "a.zst": Zstd, "a.gz.txt": Plain, "-": Plain} {
+		if got := FormatOf(name); got != want {
+			t.Errorf("FormatOf(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		tool   string // External command needed, if any
+	}{
+		{"hits.m8", Plain, ""},
+		{"hits.m8.gz", Gzip, ""},
+		{"hits.m8.bz2", Bzip2, "bzip2"},
+		{"hits.m8.zst", Zstd, "zstd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			if _, err := exec.LookPath(tt.tool); tt.tool != "" && err != nil {
+				t.Skipf("%s is not in the PATH", tt.tool)
+			}
+			path := filepath.Join(t.TempDir(), tt.name)
+			w, err := Create(path)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if w.Format != tt.format {
+				t.Errorf("Create() format = %s, want %s", w.Format, tt.format)
+			}
+			if _, err := w.WriteString(content); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			format, got := readAll(t, path)
+			if format != tt.format || got != content {
+				t.Errorf("Open() = %s %q, want %s %q", format, got, tt.format, content)
+			}
+		})
+	}
+}
+
+func TestReaderAt(t *testing.T) {
+	dir := t.TempDir()
+	plain, gz := filepath.Join(dir, "hits.m8"), filepath.Join(dir, "hits.m8.gz")
+	if err := os.WriteFile(plain, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gz, gzipContent(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{plain: true, gz: false} {
+		r, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.ReaderAt() != nil; got != want {
+			t.Errorf("ReaderAt() of %s available = %v, want %v", filepath.Base(path), got, want)
+		}
+		r.Close()
+	}
+}